@@ -20,24 +20,26 @@ func init() {
 }
 
 type a struct {
-	logger *slog.Logger
-	m1     metric.TypedDesc
-	m2     metric.TypedDesc
+	logger  *slog.Logger
+	metrics *metric.StructDescs
+}
+
+// aData declares the metrics of collector a, see metric.NewStructDescs.
+type aData struct {
+	KeyX string  `label:"key_x"`
+	KeyY string  `label:"key_y"`
+	M1   float64 `metric:"m1,gauge" help:"This is a-m1" labels:"key_x"`
+	M2   float64 `metric:"m2,counter" help:"This is a-m2" labels:"key_x,key_y" const_labels:"foo=bar"`
 }
 
 func newCollectorA(namespace string, logger *slog.Logger) (collector.Collector, error) {
+	metrics, err := metric.NewStructDescs(namespace, aSubsystem, aData{})
+	if err != nil {
+		return nil, err
+	}
 	return &a{
-		logger: logger,
-		m1: metric.TypedDesc{
-			Desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, aSubsystem, "m1"),
-				"This is a-m1", []string{"key_x"}, nil),
-			ValueType: prometheus.GaugeValue,
-		},
-		m2: metric.TypedDesc{
-			Desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, aSubsystem, "m2"),
-				"This is a-m2", []string{"key_x", "key_y"}, map[string]string{"foo": "bar"}),
-			ValueType: prometheus.CounterValue,
-		},
+		logger:  logger,
+		metrics: metrics,
 	}, nil
 }
 
@@ -49,8 +51,12 @@ var (
 )
 
 func (c a) Update(ch chan<- prometheus.Metric) error {
-	c.m1.PushMetric(ch, rand.Float64(), "m")
-	c.m2.PushMetric(ch, rand.Float64(), "m", "n")
+	c.metrics.Push(ch, aData{
+		KeyX: "m",
+		KeyY: "n",
+		M1:   rand.Float64(),
+		M2:   rand.Float64(),
+	})
 	c.logger.Info(*photoneraAddress)
 	return nil
 }
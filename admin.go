@@ -0,0 +1,98 @@
+package exporter
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+// adminHandler serves the admin API, which changes the state of the exporter at runtime.
+// Every request has to carry the admin token as bearer token. Create instances with
+// newAdminHandler.
+//
+//	GET /-/collectors        lists every collector with its default, forced and current state.
+//	PUT /-/collectors/<name> enables or disables a collector, the body is {"enabled": true|false}.
+type adminHandler struct {
+	registry *collector.Registry
+	handler  *handler // handler is rebuilt when a collector is enabled or disabled
+	token    string
+	logger   *slog.Logger
+}
+
+func newAdminHandler(registry *collector.Registry, handler *handler, token string, logger *slog.Logger) *adminHandler {
+	return &adminHandler{
+		registry: registry,
+		handler:  handler,
+		token:    token,
+		logger:   logger,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/-/collectors"), "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		h.listCollectors(w)
+	case name != "" && r.Method == http.MethodPut:
+		h.setCollectorState(w, r, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *adminHandler) listCollectors(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.registry.CollectorStates()); err != nil {
+		h.logger.Error("Couldn't encode collector states", "err", err)
+	}
+}
+
+func (h *adminHandler) setCollectorState(w http.ResponseWriter, r *http.Request, name string) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		http.Error(w, `The body has to be {"enabled": true|false}.`, http.StatusBadRequest)
+		return
+	}
+	previous := h.collectorState(name)
+	if err := h.registry.SetCollectorState(name, *body.Enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.handler.rebuild(); err != nil {
+		// Restore the previous state, so that the collector doesn't break the later rebuilds and filtered scrapes.
+		// The handler still serves the previous collection, as it is only replaced by a successful rebuild.
+		if err := h.registry.SetCollectorState(name, previous); err != nil {
+			h.logger.Error("Couldn't restore collector state", "collector", name, "err", err)
+		}
+		h.logger.Error("Couldn't rebuild metrics handler, collector state unchanged", "collector", name, "err", err)
+		http.Error(w, fmt.Sprintf("Couldn't rebuild metrics handler: %s", err), http.StatusInternalServerError)
+		return
+	}
+	h.logger.Info("Changed collector state", "collector", name, "enabled", *body.Enabled)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// collectorState returns whether the collector is currently enabled.
+func (h *adminHandler) collectorState(name string) bool {
+	for _, state := range h.registry.CollectorStates() {
+		if state.Name == name {
+			return state.Enabled
+		}
+	}
+	return false
+}
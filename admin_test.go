@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+func TestAdminCollectors(t *testing.T) {
+	t.Parallel()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      newTestRegistry(),
+		Args:          []string{"--web.admin-token-file=" + tokenFile, "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/-/collectors", "", ""); rec.Code != 401 {
+		t.Errorf("got status %d without token, want 401", rec.Code)
+	}
+	if rec := do("GET", "/-/collectors", "wrong", ""); rec.Code != 401 {
+		t.Errorf("got status %d with wrong token, want 401", rec.Code)
+	}
+	if rec := do("PUT", "/-/collectors/missing", "secret", `{"enabled": false}`); rec.Code != 404 {
+		t.Errorf("got status %d for missing collector, want 404", rec.Code)
+	}
+	if rec := do("PUT", "/-/collectors/test", "secret", `{}`); rec.Code != 400 {
+		t.Errorf("got status %d for invalid body, want 400", rec.Code)
+	}
+
+	for _, enabled := range []bool{false, true} {
+		body, _ := json.Marshal(map[string]bool{"enabled": enabled})
+		if rec := do("PUT", "/-/collectors/test", "secret", string(body)); rec.Code != 204 {
+			t.Fatalf("got status %d, want 204", rec.Code)
+		}
+
+		var states []collector.CollectorState
+		if err := json.NewDecoder(do("GET", "/-/collectors", "secret", "").Body).Decode(&states); err != nil {
+			t.Fatal(err)
+		}
+		if len(states) != 1 || states[0].Name != "test" || !states[0].DefaultEnabled || states[0].Forced || states[0].Enabled != enabled {
+			t.Errorf("got states %+v, want test collector with enabled=%v", states, enabled)
+		}
+
+		metrics, _ := io.ReadAll(do("GET", "/metrics", "", "").Body)
+		if strings.Contains(string(metrics), "test_test_up") != enabled {
+			t.Errorf("collector enabled=%v, but metrics are:\n%s", enabled, metrics)
+		}
+	}
+}
+
+func TestAdminCollectorsRebuildError(t *testing.T) {
+	t.Parallel()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry := newTestRegistry()
+	registry.RegisterCollector("broken", collector.DefaultDisabled, func(string, *slog.Logger) (collector.Collector, error) {
+		return nil, errors.New("missing credentials")
+	})
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      registry,
+		Args:          []string{"--web.admin-token-file=" + tokenFile, "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("PUT", "/-/collectors/broken", strings.NewReader(`{"enabled": true}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, req)
+	if rec.Code != 500 {
+		t.Fatalf("got status %d for a failing factory, want 500", rec.Code)
+	}
+
+	for _, state := range registry.CollectorStates() {
+		if state.Name == "broken" && state.Enabled {
+			t.Error("broken collector is still enabled after the failed rebuild")
+		}
+	}
+	for _, path := range []string{"/metrics", "/metrics?collect[]=test"} {
+		rec := httptest.NewRecorder()
+		e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if body, _ := io.ReadAll(rec.Body); rec.Code != 200 || !strings.Contains(string(body), "test_test_up 1") {
+			t.Errorf("got status %d for %s after the failed rebuild:\n%s", rec.Code, path, body)
+		}
+	}
+}
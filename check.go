@@ -0,0 +1,198 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+// checkProblem is a problem found by Check.
+type checkProblem struct {
+	collector string
+	metric    string // metric is empty if the problem is about the collector itself
+	text      string
+}
+
+func (p checkProblem) String() string {
+	if p.metric == "" {
+		return fmt.Sprintf("collector %s: %s", p.collector, p.text)
+	}
+	return fmt.Sprintf("collector %s: metric %s: %s", p.collector, p.metric, p.text)
+}
+
+// checkedFamily records the first collector which exposed a metric family, to compare it with the other collectors.
+type checkedFamily struct {
+	collector string
+	family    *dto.MetricFamily
+}
+
+// Check updates every enabled collector once and checks its metrics: the update must succeed, the metrics must pass
+// promlint and be consistent within a metric family, also across collectors (same help, type and label names).
+// The collectors registered WithInterval are checked once their first background run has finished.
+// The report is written to w, the returned error is non-nil if any problem was found.
+// It is run by the --check-config and --check-at-startup flags.
+func (e *Exporter) Check(ctx context.Context, w io.Writer) error {
+	var names []string
+	for _, state := range e.registry.CollectorStates() {
+		if state.Enabled && !state.Probe {
+			names = append(names, state.Name)
+		}
+	}
+
+	var (
+		problems []checkProblem
+		families = make(map[string]checkedFamily) // families records the metric families of all collectors by name
+	)
+	for _, name := range names {
+		// Each collector is gathered on its own, so that the problems can be attributed to it.
+		c, err := collector.NewCollection(e.registry, e.snakeCaseName, e.namespace, e.logger, name)
+		if err != nil {
+			return err
+		}
+		if err := c.WaitFirstRuns(ctx); err != nil {
+			return err
+		}
+		problems = append(problems, e.checkCollector(ctx, name, c, families)...)
+	}
+
+	slices.SortStableFunc(problems, func(a, b checkProblem) int {
+		return strings.Compare(a.collector, b.collector)
+	})
+	for _, p := range problems {
+		fmt.Fprintln(w, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "%d problem(s) found in %d collector(s)\n", len(problems), len(names))
+		return errors.New("check failed")
+	}
+	fmt.Fprintf(w, "No problems found in %d collector(s)\n", len(names))
+	return nil
+}
+
+// checkStartup runs Check and logs its report, as warnings if a problem was found.
+func (e *Exporter) checkStartup(ctx context.Context) {
+	var report strings.Builder
+	err := e.Check(ctx, &report)
+	for _, line := range strings.Split(strings.TrimSpace(report.String()), "\n") {
+		if err != nil {
+			e.logger.Warn("Startup check", "report", line)
+		} else {
+			e.logger.Info("Startup check", "report", line)
+		}
+	}
+}
+
+// checkCollector gathers the collection of a single collector and checks its metrics.
+// The metric families which are not in families yet are added, the others are compared with them.
+func (e *Exporter) checkCollector(ctx context.Context, name string, collection *collector.Collection, families map[string]checkedFamily) []checkProblem {
+	var problems []checkProblem
+	r := prometheus.NewRegistry()
+	r.MustRegister(collection.WithContext(ctx))
+	mfs, err := r.Gather()
+	if err != nil {
+		// Gather reports the inconsistencies it detects, e.g. duplicate metrics or invalid label names.
+		var multiErr prometheus.MultiError
+		if !errors.As(err, &multiErr) {
+			multiErr = prometheus.MultiError{err}
+		}
+		for _, err := range multiErr {
+			problems = append(problems, checkProblem{collector: name, text: err.Error()})
+		}
+	}
+
+	if reason, failed := updateFailure(mfs, prometheus.BuildFQName(e.namespace, "scrape", "collector_success")); failed {
+		problems = append(problems, checkProblem{collector: name, text: e.describeUpdateFailure(name, reason)})
+	}
+
+	lintProblems, err := promlint.NewWithMetricFamilies(mfs).Lint()
+	if err != nil {
+		problems = append(problems, checkProblem{collector: name, text: err.Error()})
+	}
+	for _, p := range lintProblems {
+		problems = append(problems, checkProblem{collector: name, metric: p.Metric, text: p.Text})
+	}
+
+	for _, mf := range mfs {
+		problems = append(problems, checkLabelNames(name, mf)...)
+		checked, ok := families[mf.GetName()]
+		if !ok {
+			families[mf.GetName()] = checkedFamily{collector: name, family: mf}
+			continue
+		}
+		if checked.family.GetHelp() != mf.GetHelp() {
+			problems = append(problems, checkProblem{collector: name, metric: mf.GetName(), text: fmt.Sprintf("help %q differs from %q in collector %s", mf.GetHelp(), checked.family.GetHelp(), checked.collector)})
+		}
+		if checked.family.GetType() != mf.GetType() {
+			problems = append(problems, checkProblem{collector: name, metric: mf.GetName(), text: fmt.Sprintf("type %s differs from %s in collector %s", mf.GetType(), checked.family.GetType(), checked.collector)})
+		}
+		if a, b := labelNames(mf.GetMetric()[0]), labelNames(checked.family.GetMetric()[0]); !slices.Equal(a, b) {
+			problems = append(problems, checkProblem{collector: name, metric: mf.GetName(), text: fmt.Sprintf("label names %v differ from %v in collector %s", a, b, checked.collector)})
+		}
+	}
+	return problems
+}
+
+// updateFailure returns the reason of the failure of the gathered update, from the success metric named successName.
+// An update which returned no data is not a failure.
+func updateFailure(mfs []*dto.MetricFamily, successName string) (reason string, failed bool) {
+	for _, mf := range mfs {
+		if mf.GetName() != successName {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() == 1 {
+				continue
+			}
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "reason" {
+					reason = lp.GetValue()
+				}
+			}
+			return reason, reason != "no_data"
+		}
+	}
+	return "", false
+}
+
+// describeUpdateFailure describes the failure of the update of the collector name, with its error if it is known.
+// The update has just failed, so the last error of the collector is its error.
+func (e *Exporter) describeUpdateFailure(name, reason string) string {
+	for _, state := range e.registry.CollectorStates() {
+		if state.Name == name && state.LastError != "" {
+			return "update failed: " + state.LastError
+		}
+	}
+	return "update failed with reason " + reason
+}
+
+// checkLabelNames checks that all the metrics of mf have the same label names.
+func checkLabelNames(collector string, mf *dto.MetricFamily) []checkProblem {
+	var problems []checkProblem
+	first := labelNames(mf.GetMetric()[0])
+	for _, m := range mf.GetMetric()[1:] {
+		if names := labelNames(m); !slices.Equal(names, first) {
+			problems = append(problems, checkProblem{collector: collector, metric: mf.GetName(), text: fmt.Sprintf("inconsistent label names %v and %v", first, names)})
+			break
+		}
+	}
+	return problems
+}
+
+// labelNames returns the sorted label names of m.
+func labelNames(m *dto.Metric) []string {
+	names := make([]string, 0, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		names = append(names, lp.GetName())
+	}
+	slices.Sort(names)
+	return names
+}
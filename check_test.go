@@ -0,0 +1,174 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+type badCollector struct {
+	namespace string
+}
+
+func (c badCollector) Update(ch chan<- prometheus.Metric) error {
+	requests := prometheus.NewDesc(prometheus.BuildFQName(c.namespace, "bad", "requests"), "Requests.", nil, nil)
+	ch <- prometheus.MustNewConstMetric(requests, prometheus.CounterValue, 1)
+	reused := prometheus.NewDesc(prometheus.BuildFQName(c.namespace, "test", "up"), "Other help.", nil, nil)
+	ch <- prometheus.MustNewConstMetric(reused, prometheus.GaugeValue, 1)
+	for _, labels := range [][]string{{"a"}, {"b"}} {
+		desc := prometheus.NewDesc(prometheus.BuildFQName(c.namespace, "bad", "labels"), "Labels.", labels, nil)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "value")
+	}
+	return errors.New("upstream unreachable")
+}
+
+func TestExporterCheck(t *testing.T) {
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      newTestRegistry(),
+		Args:          []string{"--check-config", "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report strings.Builder
+	if err := e.Check(context.Background(), &report); err != nil {
+		t.Fatalf("unexpected error: %s\n%s", err, report.String())
+	}
+	if !strings.Contains(report.String(), "No problems found in 1 collector(s)") {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+
+	registry := newTestRegistry()
+	registry.RegisterCollector("bad", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return badCollector{namespace: namespace}, nil
+	})
+	e, err = New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      registry,
+		Args:          []string{"--check-config", "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.Reset()
+	if err := e.Check(context.Background(), &report); err == nil {
+		t.Fatalf("expected an error, got report:\n%s", report.String())
+	}
+	for _, want := range []string{
+		`collector bad: metric test_bad_requests: counter metrics should have "_total" suffix`,
+		"collector bad: metric test_bad_labels: inconsistent label names [a] and [b]",
+		`collector test: metric test_test_up: help "Test metric." differs from "Other help." in collector bad`,
+		"collector bad: update failed: upstream unreachable",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, report.String())
+		}
+	}
+}
+
+func TestExporterCheckAtStartup(t *testing.T) {
+	for _, tc := range []struct {
+		state bool
+		want  string
+	}{
+		// Disabled collectors are not checked.
+		{collector.DefaultDisabled, "level=INFO msg=\"Startup check\" report=\"No problems found in 1 collector(s)\""},
+		{collector.DefaultEnabled, "level=WARN msg=\"Startup check\" report=\"collector bad: update failed: upstream unreachable\""},
+	} {
+		registry := newTestRegistry()
+		registry.RegisterCollector("bad", tc.state, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+			return badCollector{namespace: namespace}, nil
+		})
+		e, err := New(Options{
+			SnakeCaseName: "test_exporter",
+			Namespace:     "test",
+			Registry:      registry,
+			Args:          []string{"--check-at-startup", "--log.level=error"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !e.checkAtStartup {
+			t.Error("--check-at-startup is not set")
+		}
+		var logs strings.Builder
+		e.logger = slog.New(slog.NewTextHandler(&logs, nil))
+		e.checkStartup(context.Background())
+		if !strings.Contains(logs.String(), tc.want) {
+			t.Errorf("logs don't contain %q:\n%s", tc.want, logs.String())
+		}
+	}
+}
+
+type flakyCollector struct {
+	desc *prometheus.Desc
+	err  *atomic.Pointer[error]
+}
+
+func (c flakyCollector) Update(ch chan<- prometheus.Metric) error {
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, 1)
+	return nil
+}
+
+func TestExporterCheckOutcome(t *testing.T) {
+	var updateErr atomic.Pointer[error]
+	registry := collector.NewRegistry()
+	registry.RegisterCollector("flaky", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return flakyCollector{desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "flaky", "requests_total"), "Requests.", nil, nil), err: &updateErr}, nil
+	})
+	// The metric of the cached collector breaks the naming conventions, it is only linted after its first run.
+	registry.RegisterCollector("cached", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return flakyCollector{desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cached", "requests"), "Requests.", nil, nil), err: &atomic.Pointer[error]{}}, nil
+	}, collector.WithInterval(time.Hour))
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      registry,
+		Args:          []string{"--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.registry.ShutdownCollectors(context.Background())
+
+	for _, tc := range []struct {
+		err     error
+		want    []string
+		notWant string
+	}{
+		{errors.New("connection refused"), []string{"collector flaky: update failed: connection refused", "2 problem(s) found"}, ""},
+		// The failure of the previous check is not reported again, and no data is not a failure.
+		{nil, []string{"1 problem(s) found"}, "collector flaky"},
+		{collector.ErrNoData, []string{"1 problem(s) found"}, "collector flaky"},
+	} {
+		if tc.err != nil {
+			updateErr.Store(&tc.err)
+		} else {
+			updateErr.Store(nil)
+		}
+		var report strings.Builder
+		e.Check(context.Background(), &report)
+		for _, want := range append(tc.want, `collector cached: metric test_cached_requests: counter metrics should have "_total" suffix`) {
+			if !strings.Contains(report.String(), want) {
+				t.Errorf("%v: report doesn't contain %q:\n%s", tc.err, want, report.String())
+			}
+		}
+		if tc.notWant != "" && strings.Contains(report.String(), tc.notWant) {
+			t.Errorf("%v: report contains %q:\n%s", tc.err, tc.notWant, report.String())
+		}
+	}
+}
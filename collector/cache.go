@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cachedCollector updates a collector in the background and caches the metrics of its last successful run.
+// It is created in NewCollection for the collectors registered WithInterval.
+type cachedCollector struct {
+	collector Collector
+	interval  time.Duration
+	timeout   time.Duration
+	status    *status         // status records the outcome of every run, it can be nil
+	sems      []chan struct{} // sems are the semaphores of the concurrency limits a run has to acquire, see acquire
+	logger    *slog.Logger
+	cancel    context.CancelFunc
+	done      chan struct{}
+	firstRun  chan struct{} // firstRun is closed once the first run has finished, see Collection.WaitFirstRuns
+	disabled  atomic.Bool   // disabled pauses the background updates while the collector is disabled, see Registry.SetCollectorState
+
+	mtx         sync.RWMutex
+	metrics     []prometheus.Metric // metrics of the last successful run, including partially successful ones
+	lastSuccess time.Time           // lastSuccess is the end time of the last successful run
+	duration    time.Duration       // duration of the last run
+	err         error               // err returned by the last run
+}
+
+// newCachedCollector creates a cachedCollector and starts updating the collector in the background.
+// If timeout is zero, a run times out after interval. Every run acquires a slot of each semaphore of sems.
+func newCachedCollector(collector Collector, interval, timeout time.Duration, status *status, sems []chan struct{}, logger *slog.Logger) *cachedCollector {
+	if timeout <= 0 {
+		timeout = interval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cachedCollector{
+		collector: collector,
+		interval:  interval,
+		timeout:   timeout,
+		status:    status,
+		sems:      sems,
+		logger:    logger,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		firstRun:  make(chan struct{}),
+		err:       ErrNoData,
+	}
+	go c.loop(ctx)
+	return c
+}
+
+func (c *cachedCollector) loop(ctx context.Context) {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	c.run(ctx)
+	close(c.firstRun)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.run(ctx)
+	}
+}
+
+// run updates the collector once, records its outcome in c.status and replaces the cached metrics if it succeeded.
+// The outcome is only recorded here, once per run, as the scrapes merely replay it.
+// Disabled and quarantined collectors are not updated, see WithPanicQuarantine.
+func (c *cachedCollector) run(ctx context.Context) {
+	if c.disabled.Load() || (c.status != nil && c.status.isQuarantined()) {
+		return
+	}
+	metricCh := make(chan prometheus.Metric)
+	metricsCh := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range metricCh {
+			metrics = append(metrics, m)
+		}
+		metricsCh <- metrics
+	}()
+
+	begin := time.Now()
+	var pushErrs []error
+	// The wait for the concurrency slots is part of the run, like in a scrape.
+	timeoutCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	release, err := acquire(timeoutCtx, c.sems...)
+	if err == nil {
+		pushErrs, err = collect(timeoutCtx, c.timeout, c.collector, metricCh, release)
+	}
+	duration := time.Since(begin)
+	close(metricCh)
+	metrics := <-metricsCh
+
+	if len(pushErrs) > 0 {
+		c.logger.Warn("collector failed to create metrics", "count", len(pushErrs), "err", errors.Join(pushErrs...))
+		if c.status != nil {
+			c.status.recordPushErrors(len(pushErrs))
+		}
+	}
+
+	success, reason := updateOutcome(err)
+	// A run interrupted by Shutdown is not an outcome of the collector.
+	if c.status != nil && ctx.Err() == nil {
+		c.status.record(duration, success, reason, err)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.duration = duration
+	c.err = err
+	if success {
+		c.metrics = metrics
+		c.lastSuccess = time.Now()
+	}
+}
+
+// Update implements Collector, it pushes the cached metrics.
+func (c *cachedCollector) Update(ch chan<- prometheus.Metric) error {
+	_, _, err := c.replay(ch)
+	return err
+}
+
+// replay pushes the cached metrics and returns the duration and the error of the last run,
+// along with the end time of the last successful run, which is zero if there has been none.
+func (c *cachedCollector) replay(ch chan<- prometheus.Metric) (time.Duration, time.Time, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for _, m := range c.metrics {
+		ch <- m
+	}
+	return c.duration, c.lastSuccess, c.err
+}
+
+// Reload implements Reloadable, it reloads the collector if it implements Reloadable.
+func (c *cachedCollector) Reload(config any) error {
+	if reloadable, ok := c.collector.(Reloadable); ok {
+		return reloadable.Reload(config)
+	}
+	return nil
+}
+
+// Shutdown implements Shutdowner, it stops the background updates and shuts down the collector.
+func (c *cachedCollector) Shutdown(ctx context.Context) error {
+	c.cancel()
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return shutdown(ctx, c.collector)
+}
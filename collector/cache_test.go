@@ -0,0 +1,235 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+type countingCollector struct {
+	desc  *prometheus.Desc
+	runs  atomic.Int64
+	fails atomic.Bool
+}
+
+func (c *countingCollector) Update(ch chan<- prometheus.Metric) error {
+	runs := c.runs.Add(1)
+	if c.fails.Load() {
+		return fmt.Errorf("%w: connection refused", ErrUpstreamUnavailable)
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(runs))
+	return nil
+}
+
+func TestCachedCollector(t *testing.T) {
+	t.Parallel()
+	counting := &countingCollector{
+		desc: prometheus.NewDesc("test_runs", "Number of runs.", nil, nil),
+	}
+	registry := NewRegistry()
+	registry.RegisterCollector("counting", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return counting, nil
+	}, WithInterval(time.Hour))
+	enabled := true
+	registry.collectorState["counting"] = &enabled
+	defer registry.ShutdownCollectors(context.Background())
+
+	c, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := unchecked{c}
+	deadline := time.Now().Add(time.Second)
+	for testutil.CollectAndCount(collection, "test_runs") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("background collection didn't finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Scrapes are served from the cache, even if the collector fails afterwards.
+	counting.fails.Store(true)
+	c.Collectors["counting"].(*cachedCollector).run(context.Background())
+	expected := `
+# HELP test_runs Number of runs.
+# TYPE test_runs gauge
+test_runs 1
+# HELP test_scrape_collector_success test_exporter: Whether a collector succeeded.
+# TYPE test_scrape_collector_success gauge
+test_scrape_collector_success{collector="counting",reason="upstream_unavailable"} 0
+`
+	for i := 0; i < 3; i++ {
+		if err := testutil.CollectAndCompare(collection, strings.NewReader(expected), "test_runs", "test_scrape_collector_success"); err != nil {
+			t.Error(err)
+		}
+	}
+	if n := testutil.CollectAndCount(collection, "test_scrape_collector_last_success_timestamp_seconds"); n != 1 {
+		t.Errorf("got %d last success metrics, want 1", n)
+	}
+	if runs := counting.runs.Load(); runs != 2 {
+		t.Errorf("collector ran %d times, want 2", runs)
+	}
+}
+
+// unchecked is an unchecked prometheus.Collector, so that the metrics of the collectors
+// don't have to be described to pass the checks of testutil.
+type unchecked struct {
+	prometheus.Collector
+}
+
+func (unchecked) Describe(ch chan<- *prometheus.Desc) {}
+
+type panickingOnceCollector struct {
+	desc *prometheus.Desc
+	runs atomic.Int64
+}
+
+func (c *panickingOnceCollector) Update(ch chan<- prometheus.Metric) error {
+	if c.runs.Add(1) == 1 {
+		panic("first run")
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestCachedCollectorStatus(t *testing.T) {
+	t.Parallel()
+	panicking := &panickingOnceCollector{
+		desc: prometheus.NewDesc("test_value", "Value.", nil, nil),
+	}
+	registry := NewRegistry()
+	registry.RegisterCollector("panicking", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return panicking, nil
+	}, WithInterval(time.Hour), WithPanicQuarantine(2))
+	enabled := true
+	registry.collectorState["panicking"] = &enabled
+	defer registry.ShutdownCollectors(context.Background())
+
+	c, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := c.Collectors["panicking"].(*cachedCollector)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, err := cached.replay(make(chan prometheus.Metric)); err != ErrNoData {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background collection didn't finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The failed run is replayed by every scrape, but only counted once.
+	expected := `
+# HELP test_scrape_collector_errors_total test_exporter: Number of failed collector updates by reason.
+# TYPE test_scrape_collector_errors_total counter
+test_scrape_collector_errors_total{collector="panicking",reason="panic"} 1
+`
+	for i := 0; i < 3; i++ {
+		if err := testutil.CollectAndCompare(unchecked{c}, strings.NewReader(expected), "test_scrape_collector_errors_total"); err != nil {
+			t.Error(err)
+		}
+	}
+	state := registry.CollectorStates()[0]
+	if state.Quarantined {
+		t.Error("collector was quarantined after a single failed run")
+	}
+	if state.LastSuccess != nil || !strings.Contains(state.LastError, "first run") {
+		t.Errorf("unexpected state after the failed run: %+v", state)
+	}
+
+	cached.run(context.Background())
+	if state := registry.CollectorStates()[0]; state.LastSuccess == nil {
+		t.Errorf("last success wasn't recorded by the successful run: %+v", state)
+	}
+}
+
+func TestCachedCollectorDisabled(t *testing.T) {
+	t.Parallel()
+	counting := &countingCollector{
+		desc: prometheus.NewDesc("test_runs", "Number of runs.", nil, nil),
+	}
+	registry := NewRegistry()
+	registry.RegisterCollector("counting", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return counting, nil
+	}, WithInterval(time.Hour))
+	enabled := true
+	registry.collectorState["counting"] = &enabled
+	defer registry.ShutdownCollectors(context.Background())
+
+	c, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := c.Collectors["counting"].(*cachedCollector)
+	deadline := time.Now().Add(time.Second)
+	for counting.runs.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("background collection didn't finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := registry.SetCollectorState("counting", false); err != nil {
+		t.Fatal(err)
+	}
+	cached.run(context.Background())
+	if runs := counting.runs.Load(); runs != 1 {
+		t.Errorf("disabled collector ran %d times, want 1", runs)
+	}
+
+	if err := registry.SetCollectorState("counting", true); err != nil {
+		t.Fatal(err)
+	}
+	cached.run(context.Background())
+	if runs := counting.runs.Load(); runs != 2 {
+		t.Errorf("re-enabled collector ran %d times, want 2", runs)
+	}
+}
+
+func TestCachedCollectorConcurrencyGroup(t *testing.T) {
+	t.Parallel()
+	counting := &countingCollector{
+		desc: prometheus.NewDesc("test_runs", "Number of runs.", nil, nil),
+	}
+	registry := NewRegistry()
+	registry.RegisterCollector("counting", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return counting, nil
+	}, WithInterval(time.Hour), WithConcurrencyGroup("db", 1))
+	enabled := true
+	registry.collectorState["counting"] = &enabled
+	defer registry.ShutdownCollectors(context.Background())
+
+	// Another collector of the group is updating, the first background run has to wait for it.
+	db := registry.concurrencyGroups["db"]
+	db <- struct{}{}
+	c, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if runs := counting.runs.Load(); runs != 0 {
+		t.Fatalf("collector ran %d times while its group was busy, want 0", runs)
+	}
+
+	<-db
+	if err := c.WaitFirstRuns(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if runs := counting.runs.Load(); runs != 1 {
+		t.Errorf("collector ran %d times once its group was free, want 1", runs)
+	}
+	if len(db) != 0 {
+		t.Error("the slot of the group wasn't released after the run")
+	}
+}
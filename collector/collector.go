@@ -2,9 +2,13 @@
 package collector
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,26 +16,36 @@ import (
 	"github.com/rea1shane/exporter/metric"
 )
 
-var (
-	initiatedCollectorsMtx = sync.Mutex{}               // initiatedCollectorsMtx avoid thread conflicts
-	initiatedCollectors    = make(map[string]Collector) // initiatedCollectors record the collectors that have been initialized in the method NewCollection (To reduce the collector's construction method call)
-)
-
 // Collection implements the prometheus.Collector interface.
 type Collection struct {
 	Collectors         map[string]Collector
+	statuses           map[string]*status       // statuses records the outcome of each collector's updates
+	timeouts           map[string]time.Duration // timeouts records the timeout of each collector, zero means no timeout
+	ctx                context.Context          // ctx is passed to every ContextCollector, see WithContext
+	concurrency        chan struct{}            // concurrency limits the number of collectors updating at once, nil if unlimited
+	groups             map[string]chan struct{} // groups records the semaphore of the concurrency group of each collector which is in one
+	dependencies       map[string][]string      // dependencies records the collectors each collector waits for in a scrape, see WithDependencies
 	logger             *slog.Logger
 	scrapeDurationDesc metric.TypedDesc
 	scrapeSuccessDesc  metric.TypedDesc
+	lastSuccessDesc    metric.TypedDesc
+	errorsDesc         metric.TypedDesc
+	partialDesc        metric.TypedDesc
+	pushErrorsDesc     metric.TypedDesc
+	circuitStateDesc   metric.TypedDesc
+	probeSuccessDesc   metric.TypedDesc // probeSuccessDesc is only set in the collections created by NewProbeCollection
+	probeDurationDesc  metric.TypedDesc // probeDurationDesc is only set in the collections created by NewProbeCollection
 }
 
-// NewCollection creates a new Collection.
+// NewCollection creates a new Collection of the enabled collectors in registry.
 // Namespace defines the common namespace to be used by all metrics.
-func NewCollection(snakeCaseName, namespace string, logger *slog.Logger, filters ...string) (*Collection, error) {
+func NewCollection(registry *Registry, snakeCaseName, namespace string, logger *slog.Logger, filters ...string) (*Collection, error) {
+	registry.initiatedCollectorsMtx.Lock()
+	defer registry.initiatedCollectorsMtx.Unlock()
 	f := make(map[string]bool)
 	for _, filter := range filters {
-		enabled, exist := collectorState[filter]
-		if !exist {
+		enabled, exist := registry.collectorState[filter]
+		if _, ok := registry.probeFactories[filter]; !exist || ok {
 			return nil, fmt.Errorf("missing collector: %s", filter)
 		}
 		if !*enabled {
@@ -40,26 +54,138 @@ func NewCollection(snakeCaseName, namespace string, logger *slog.Logger, filters
 		f[filter] = true
 	}
 	collectors := make(map[string]Collector)
-	initiatedCollectorsMtx.Lock()
-	defer initiatedCollectorsMtx.Unlock()
-	for key, enabled := range collectorState {
+	statuses := make(map[string]*status)
+	timeouts := make(map[string]time.Duration)
+	for key, enabled := range registry.collectorState {
+		if _, ok := registry.probeFactories[key]; ok {
+			continue
+		}
 		if !*enabled || (len(f) > 0 && !f[key]) {
 			continue
 		}
-		if collector, ok := initiatedCollectors[key]; ok {
+		if s, ok := registry.statuses[key]; ok {
+			statuses[key] = s
+		}
+		if timeout, ok := registry.collectorTimeouts[key]; ok {
+			timeouts[key] = *timeout
+		}
+		if collector, ok := registry.initiatedCollectors[key]; ok {
 			collectors[key] = collector
 		} else {
-			collector, err := factories[key](namespace, logger.With("collector", key))
+			collector, err := registry.factories[key](namespace, logger.With("collector", key))
 			if err != nil {
 				return nil, err
 			}
+			if err := registry.reload(key, collector); err != nil {
+				return nil, err
+			}
+			if interval := registry.options[key].interval; interval > 0 {
+				collector = newCachedCollector(collector, interval, timeouts[key], statuses[key], []chan struct{}{registry.concurrencyGroups[registry.options[key].concurrencyGroup], registry.concurrency}, logger.With("collector", key))
+			}
 			collectors[key] = collector
-			initiatedCollectors[key] = collector
+			registry.initiatedCollectors[key] = collector
+		}
+	}
+	c := newCollection(snakeCaseName, namespace, logger)
+	c.Collectors = collectors
+	c.statuses = statuses
+	c.timeouts = timeouts
+	c.concurrency = registry.concurrency
+	c.groups = registry.collectorGroups(collectors)
+	dependencies, err := registry.collectorDependencies(collectors)
+	if err != nil {
+		return nil, err
+	}
+	c.dependencies = dependencies
+	return c, nil
+}
+
+// NewProbeCollection creates a new Collection of the enabled probe collectors in registry, see RegisterProbeCollector.
+// The collectors are created for target and module on every call and are not reused, shut them down with
+// ShutdownCollection once the collection has been collected.
+// Besides the metrics of NewCollection, it exposes the probe_success and probe_duration_seconds metrics.
+func NewProbeCollection(registry *Registry, snakeCaseName, namespace, target, module string, logger *slog.Logger) (*Collection, error) {
+	logger = logger.With("target", target, "module", module)
+	collectors := make(map[string]Collector)
+	statuses := make(map[string]*status)
+	timeouts := make(map[string]time.Duration)
+	for key, factory := range registry.probeFactories {
+		registry.initiatedCollectorsMtx.Lock()
+		enabled, ok := registry.collectorState[key]
+		ok = ok && *enabled
+		registry.initiatedCollectorsMtx.Unlock()
+		if !ok {
+			continue
+		}
+		// Every probe has its own status, so that the failures of a target neither show up in the metrics
+		// of the other targets nor quarantine the collector or open its circuit breaker for them.
+		statuses[key] = newStatus(options{})
+		timeouts[key] = *registry.collectorTimeouts[key]
+		collector, err := factory(target, module, namespace, logger.With("collector", key))
+		if err != nil {
+			ShutdownCollection(context.Background(), &Collection{Collectors: collectors})
+			return nil, err
+		}
+		collectors[key] = collector
+		registry.initiatedCollectorsMtx.Lock()
+		err = registry.reload(key, collector)
+		registry.initiatedCollectorsMtx.Unlock()
+		if err != nil {
+			ShutdownCollection(context.Background(), &Collection{Collectors: collectors})
+			return nil, err
 		}
 	}
+
+	c := newCollection(snakeCaseName, namespace, logger)
+	c.Collectors = collectors
+	c.statuses = statuses
+	c.timeouts = timeouts
+	registry.initiatedCollectorsMtx.Lock()
+	c.concurrency = registry.concurrency
+	registry.initiatedCollectorsMtx.Unlock()
+	c.groups = registry.collectorGroups(collectors)
+	dependencies, err := registry.collectorDependencies(collectors)
+	if err != nil {
+		ShutdownCollection(context.Background(), c)
+		return nil, err
+	}
+	c.dependencies = dependencies
+	c.probeSuccessDesc = metric.TypedDesc{
+		Desc: prometheus.NewDesc(
+			"probe_success",
+			"Displays whether or not the probe was a success.",
+			nil,
+			nil,
+		),
+		ValueType: prometheus.GaugeValue,
+	}
+	c.probeDurationDesc = metric.TypedDesc{
+		Desc: prometheus.NewDesc(
+			"probe_duration_seconds",
+			"Returns how long the probe took to complete in seconds.",
+			nil,
+			nil,
+		),
+		ValueType: prometheus.GaugeValue,
+	}
+	return c, nil
+}
+
+// ShutdownCollection shuts down the collectors of a Collection created by NewProbeCollection.
+func ShutdownCollection(ctx context.Context, c *Collection) error {
+	var errs []error
+	for name, collector := range c.Collectors {
+		if err := shutdown(ctx, collector); err != nil {
+			errs = append(errs, fmt.Errorf("couldn't shut down %s collector: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newCollection creates an empty Collection with the descriptors of its own metrics.
+func newCollection(snakeCaseName, namespace string, logger *slog.Logger) *Collection {
 	return &Collection{
-		Collectors: collectors,
-		logger:     logger,
+		logger: logger,
 		scrapeDurationDesc: metric.TypedDesc{
 			Desc: prometheus.NewDesc(
 				prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
@@ -73,50 +199,336 @@ func NewCollection(snakeCaseName, namespace string, logger *slog.Logger, filters
 			Desc: prometheus.NewDesc(
 				prometheus.BuildFQName(namespace, "scrape", "collector_success"),
 				snakeCaseName+": Whether a collector succeeded.",
+				[]string{"collector", "reason"},
+				nil,
+			),
+			ValueType: prometheus.GaugeValue,
+		},
+		lastSuccessDesc: metric.TypedDesc{
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "scrape", "collector_last_success_timestamp_seconds"),
+				snakeCaseName+": Last time a collector running in the background succeeded.",
+				[]string{"collector"},
+				nil,
+			),
+			ValueType: prometheus.GaugeValue,
+		},
+		errorsDesc: metric.TypedDesc{
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "scrape", "collector_errors_total"),
+				snakeCaseName+": Number of failed collector updates by reason.",
+				[]string{"collector", "reason"},
+				nil,
+			),
+			ValueType: prometheus.CounterValue,
+		},
+		partialDesc: metric.TypedDesc{
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "scrape", "collector_partial_failures"),
+				snakeCaseName+": Number of failed sub-units of a collector which partially succeeded.",
 				[]string{"collector"},
 				nil,
 			),
 			ValueType: prometheus.GaugeValue,
 		},
-	}, nil
+		pushErrorsDesc: metric.TypedDesc{
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "scrape", "collector_push_errors_total"),
+				snakeCaseName+": Number of metrics a collector failed to create, e.g. because of an unconvertible value or a wrong number of label values.",
+				[]string{"collector"},
+				nil,
+			),
+			ValueType: prometheus.CounterValue,
+		},
+		circuitStateDesc: metric.TypedDesc{
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "scrape", "collector_circuit_state"),
+				snakeCaseName+": State of the circuit breaker of a collector (0 closed, 1 open, 2 half-open).",
+				[]string{"collector"},
+				nil,
+			),
+			ValueType: prometheus.GaugeValue,
+		},
+	}
+}
+
+// WithContext returns a shallow copy of the collection whose collectors will be updated with ctx.
+// Only the collectors which implement ContextCollector are aware of ctx.
+func (c Collection) WithContext(ctx context.Context) *Collection {
+	c.ctx = ctx
+	return &c
+}
+
+// WaitFirstRuns waits until the collectors registered WithInterval have finished their first background run,
+// so that their metrics are cached, or until ctx is done. Until then, they are served with ErrNoData.
+func (c Collection) WaitFirstRuns(ctx context.Context) error {
+	for _, collector := range c.Collectors {
+		cached, ok := collector.(*cachedCollector)
+		if !ok {
+			continue
+		}
+		select {
+		case <-cached.firstRun:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
 }
 
 // Describe implements the prometheus.Collector interface.
 func (c Collection) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.scrapeDurationDesc.Desc
 	ch <- c.scrapeSuccessDesc.Desc
+	ch <- c.lastSuccessDesc.Desc
+	ch <- c.errorsDesc.Desc
+	ch <- c.partialDesc.Desc
+	ch <- c.pushErrorsDesc.Desc
+	ch <- c.circuitStateDesc.Desc
+	if c.probeSuccessDesc.Desc != nil {
+		ch <- c.probeSuccessDesc.Desc
+		ch <- c.probeDurationDesc.Desc
+	}
 }
 
 // Collect implements the prometheus.Collector interface.
+// The collectors of a scrape share the results of Fetch.
 func (c Collection) Collect(ch chan<- prometheus.Metric) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = newScrapeContext(ctx)
+	begin := time.Now()
+	var failed atomic.Bool
+	// done records for each collector a channel which is closed once it has been updated, see WithDependencies.
+	done := make(map[string]chan struct{}, len(c.Collectors))
+	for name := range c.Collectors {
+		done[name] = make(chan struct{})
+	}
 	wg := sync.WaitGroup{}
 	wg.Add(len(c.Collectors))
 	for name, collector := range c.Collectors {
 		go func(name string, collector Collector) {
-			execute(name, collector, ch, c.logger, c.scrapeDurationDesc, c.scrapeSuccessDesc)
+			defer close(done[name])
+			if !c.execute(ctx, name, collector, done, ch) {
+				failed.Store(true)
+			}
 			wg.Done()
 		}(name, collector)
 	}
 	wg.Wait()
+
+	if c.probeSuccessDesc.Desc != nil {
+		var success float64
+		if !failed.Load() {
+			success = 1
+		}
+		c.probeSuccessDesc.PushMetric(ch, success)
+		c.probeDurationDesc.PushMetric(ch, time.Since(begin).Seconds())
+	}
 }
 
-func execute(name string, c Collector, ch chan<- prometheus.Metric, logger *slog.Logger, scrapeDurationDesc, scrapeSuccessDesc metric.TypedDesc) {
-	begin := time.Now()
-	err := c.Update(ch)
-	duration := time.Since(begin)
-	var success float64
+// execute updates a single collector, pushes its scrape duration and success metrics and returns whether it succeeded.
+// Collectors running in the background push their cached metrics and the duration and
+// success of their last run instead.
+// Collectors with dependencies wait until the done channels of their dependencies are closed.
+func (c Collection) execute(ctx context.Context, name string, collector Collector, done map[string]chan struct{}, ch chan<- prometheus.Metric) bool {
+	var (
+		duration time.Duration
+		err      error
+	)
+	s, hasStatus := c.statuses[name]
+	cached, isCached := collector.(*cachedCollector)
+	if hasStatus && s.isQuarantined() {
+		err = ErrQuarantined
+	} else if hasStatus && !isCached && !s.allowUpdate() {
+		err = ErrCircuitOpen
+	} else if isCached {
+		var lastSuccess time.Time
+		duration, lastSuccess, err = cached.replay(ch)
+		if !lastSuccess.IsZero() {
+			c.lastSuccessDesc.PushMetric(ch, float64(lastSuccess.UnixNano())/1e9, name)
+		}
+	} else {
+		begin := time.Now()
+		var (
+			pushErrs []error
+			release  func()
+		)
+		if err = c.waitDependencies(ctx, name, done); err == nil {
+			release, err = c.acquire(ctx, name)
+		}
+		if err == nil {
+			pushErrs, err = collect(ctx, c.timeouts[name], collector, ch, release)
+		}
+		duration = time.Since(begin)
+		if len(pushErrs) > 0 {
+			c.logger.Warn("collector failed to create metrics", "name", name, "count", len(pushErrs), "err", errors.Join(pushErrs...))
+			if hasStatus {
+				s.recordPushErrors(len(pushErrs))
+			}
+		}
+	}
+	var (
+		success         float64
+		reason          string
+		partialFailures int
+		partialErr      *PartialError
+	)
 
-	if err != nil {
+	if errors.As(err, &partialErr) {
+		for _, f := range partialErr.Failures {
+			c.logger.Warn("collector partially failed", "name", name, "key", f.Key, "err", f.Err)
+		}
+		c.logger.Debug("collector partially succeeded", "name", name, "duration_seconds", duration.Seconds(), "failures", len(partialErr.Failures))
+		partialFailures = len(partialErr.Failures)
+		success = 1
+	} else if err != nil {
+		reason = errorReason(err)
+		var panicErr *PanicError
 		if isNoDataError(err) {
-			logger.Debug("collector returned no data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+			c.logger.Debug("collector returned no data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+		} else if errors.Is(err, ErrQuarantined) {
+			c.logger.Debug("collector is quarantined", "name", name)
+		} else if errors.Is(err, ErrCircuitOpen) {
+			c.logger.Debug("collector is skipped by its circuit breaker", "name", name)
+		} else if errors.As(err, &panicErr) {
+			c.logger.Error("collector panicked", "name", name, "duration_seconds", duration.Seconds(), "err", err, "stack", string(panicErr.Stack))
 		} else {
-			logger.Error("collector failed", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+			c.logger.Error("collector failed", "name", name, "reason", reason, "duration_seconds", duration.Seconds(), "err", err)
 		}
 		success = 0
 	} else {
-		logger.Debug("collector succeeded", "name", name, "duration_seconds", duration.Seconds())
+		c.logger.Debug("collector succeeded", "name", name, "duration_seconds", duration.Seconds())
 		success = 1
 	}
-	scrapeDurationDesc.PushMetric(ch, duration.Seconds(), name)
-	scrapeSuccessDesc.PushMetric(ch, success, name)
+	c.scrapeDurationDesc.PushMetric(ch, duration.Seconds(), name)
+	c.scrapeSuccessDesc.PushMetric(ch, success, name, reason)
+	c.partialDesc.PushMetric(ch, partialFailures, name)
+
+	if hasStatus {
+		// The runs of cached collectors are recorded by cachedCollector.run, not their replays.
+		if !isCached {
+			s.record(duration, success == 1, reason, err)
+		}
+		for reason, count := range s.errorCounts() {
+			c.errorsDesc.PushMetric(ch, count, name, reason)
+		}
+		c.pushErrorsDesc.PushMetric(ch, s.pushErrorCount(), name)
+		c.circuitStateDesc.PushMetric(ch, int(s.circuitState()), name)
+	}
+	return success == 1
+}
+
+// waitDependencies waits until the dependencies of the collector have been updated.
+func (c Collection) waitDependencies(ctx context.Context, name string, done map[string]chan struct{}) error {
+	for _, dependency := range c.dependencies[name] {
+		select {
+		case <-done[dependency]:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%w while waiting for the %s collector: %w", ErrTimeout, dependency, ctx.Err())
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// acquire waits until the collector may be updated without exceeding the limit of its concurrency group
+// and the limit of all collectors. The group is acquired first, so that a collector waiting for its group
+// doesn't hold back the others. The returned function releases the slots, it is passed to collect.
+func (c Collection) acquire(ctx context.Context, name string) (release func(), err error) {
+	return acquire(ctx, c.groups[name], c.concurrency)
+}
+
+// acquire takes a slot of every semaphore of sems in order, nil semaphores are skipped, see Collection.acquire.
+func acquire(ctx context.Context, sems ...chan struct{}) (release func(), err error) {
+	var acquired []chan struct{}
+	release = func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+	}
+	for _, sem := range sems {
+		if sem == nil {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+		case <-ctx.Done():
+			release()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w while waiting for its turn: %w", ErrTimeout, ctx.Err())
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// collect updates a collector and forwards its metrics to ch.
+// The metrics which couldn't be created (see metric.PushError) are not forwarded, their errors are returned as pushErrs.
+// If timeout or the deadline of ctx is exceeded, collect stops waiting for the collector,
+// its further metrics are discarded and the error of ctx is returned.
+// release, if not nil, is called once the update has returned, which may be after collect gave up on it,
+// so that the concurrency slots of a hung collector are held until it actually returns, see acquire.
+func collect(ctx context.Context, timeout time.Duration, collector Collector, ch chan<- prometheus.Metric, release func()) (pushErrs []error, err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// The collector writes to its own channel, so that it can't send to ch
+	// anymore after collect has given up on it.
+	updateCh := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+	go func() {
+		if release != nil {
+			defer release()
+		}
+		defer close(updateCh)
+		errCh <- update(ctx, collector, updateCh)
+	}()
+
+	for {
+		select {
+		case m, ok := <-updateCh:
+			if !ok {
+				return pushErrs, <-errCh
+			}
+			if err := metric.PushError(m); err != nil {
+				pushErrs = append(pushErrs, err)
+				continue
+			}
+			ch <- m
+		case <-ctx.Done():
+			go func() {
+				for range updateCh {
+				}
+			}()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return pushErrs, fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+			}
+			return pushErrs, ctx.Err()
+		}
+	}
+}
+
+// update calls UpdateWithContext if the collector implements ContextCollector, otherwise Update.
+// A panic of the collector is recovered and returned as a PanicError. Panics in the goroutines
+// started by the collector itself can't be recovered.
+func update(ctx context.Context, c Collector, ch chan<- prometheus.Metric) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	if cc, ok := c.(ContextCollector); ok {
+		return cc.UpdateWithContext(ctx, ch)
+	}
+	return c.Update(ch)
 }
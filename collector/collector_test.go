@@ -0,0 +1,354 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+
+	"github.com/rea1shane/exporter/metric"
+)
+
+type contextKey struct{}
+
+type plainCollector struct {
+	called bool
+}
+
+func (c *plainCollector) Update(ch chan<- prometheus.Metric) error {
+	c.called = true
+	return nil
+}
+
+type contextCollector struct {
+	updateCalled bool
+	value        any
+}
+
+func (c *contextCollector) Update(ch chan<- prometheus.Metric) error {
+	c.updateCalled = true
+	return nil
+}
+
+func (c *contextCollector) UpdateWithContext(ctx context.Context, ch chan<- prometheus.Metric) error {
+	c.value = ctx.Value(contextKey{})
+	return nil
+}
+
+func newTestCollection(t *testing.T, collectors map[string]Collector) *Collection {
+	t.Helper()
+	registry := NewRegistry()
+	for name, c := range collectors {
+		enabled := true
+		registry.collectorState[name] = &enabled
+		registry.initiatedCollectors[name] = c
+		registry.statuses[name] = newStatus(options{})
+	}
+	collection, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collection
+}
+
+func TestCollectionContext(t *testing.T) {
+	t.Parallel()
+	plain := &plainCollector{}
+	withContext := &contextCollector{}
+	collection := newTestCollection(t, map[string]Collector{
+		"plain":   plain,
+		"context": withContext,
+	})
+
+	ctx := context.WithValue(context.Background(), contextKey{}, "scrape")
+	if n := testutil.CollectAndCount(collection.WithContext(ctx), "test_scrape_collector_success"); n != 2 {
+		t.Errorf("expected 2 collector_success metrics, got %d", n)
+	}
+
+	if !plain.called {
+		t.Error("Update of plain collector was not called")
+	}
+	if withContext.updateCalled {
+		t.Error("Update was called although the collector implements ContextCollector")
+	}
+	if withContext.value != "scrape" {
+		t.Errorf("UpdateWithContext got context value %v, want %q", withContext.value, "scrape")
+	}
+}
+
+type blockingCollector struct{}
+
+func (blockingCollector) Update(ch chan<- prometheus.Metric) error {
+	select {}
+}
+
+func (blockingCollector) UpdateWithContext(ctx context.Context, ch chan<- prometheus.Metric) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type hangingCollector struct{}
+
+func (hangingCollector) Update(ch chan<- prometheus.Metric) error {
+	time.Sleep(time.Second)
+	return nil
+}
+
+func TestCollectionTimeout(t *testing.T) {
+	t.Parallel()
+	collection := newTestCollection(t, map[string]Collector{
+		"plain":    &plainCollector{},
+		"blocking": blockingCollector{},
+		"hanging":  hangingCollector{},
+	})
+	collection.timeouts["hanging"] = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	expected := `
+# HELP test_scrape_collector_success test_exporter: Whether a collector succeeded.
+# TYPE test_scrape_collector_success gauge
+test_scrape_collector_success{collector="blocking",reason="timeout"} 0
+test_scrape_collector_success{collector="hanging",reason="timeout"} 0
+test_scrape_collector_success{collector="plain",reason=""} 1
+`
+	if err := testutil.CollectAndCompare(collection.WithContext(ctx), strings.NewReader(expected), "test_scrape_collector_success"); err != nil {
+		t.Error(err)
+	}
+}
+
+type partialCollector struct{}
+
+func (partialCollector) Update(ch chan<- prometheus.Metric) error {
+	var partialErr PartialError
+	partialErr.Add("target-1", ErrUpstreamUnavailable)
+	partialErr.Add("target-2", ErrAuth)
+	return partialErr.ErrorOrNil()
+}
+
+func TestCollectionPartialError(t *testing.T) {
+	t.Parallel()
+	collection := newTestCollection(t, map[string]Collector{
+		"partial": partialCollector{},
+		"plain":   &plainCollector{},
+	})
+
+	expected := `
+# HELP test_scrape_collector_partial_failures test_exporter: Number of failed sub-units of a collector which partially succeeded.
+# TYPE test_scrape_collector_partial_failures gauge
+test_scrape_collector_partial_failures{collector="partial"} 2
+test_scrape_collector_partial_failures{collector="plain"} 0
+# HELP test_scrape_collector_success test_exporter: Whether a collector succeeded.
+# TYPE test_scrape_collector_success gauge
+test_scrape_collector_success{collector="partial",reason=""} 1
+test_scrape_collector_success{collector="plain",reason=""} 1
+`
+	if err := testutil.CollectAndCompare(collection, strings.NewReader(expected), "test_scrape_collector_success", "test_scrape_collector_partial_failures"); err != nil {
+		t.Error(err)
+	}
+}
+
+type invalidValueCollector struct{}
+
+func (invalidValueCollector) Update(ch chan<- prometheus.Metric) error {
+	desc := metric.TypedDesc{
+		Desc:      prometheus.NewDesc("test_value", "Value.", []string{"key"}, nil),
+		ValueType: prometheus.GaugeValue,
+	}
+	desc.PushMetric(ch, 1, "valid")
+	desc.PushMetric(ch, "not a number", "invalid")
+	desc.PushMetric(ch, 2)
+	return nil
+}
+
+func TestCollectionPushErrors(t *testing.T) {
+	t.Parallel()
+	collection := newTestCollection(t, map[string]Collector{
+		"invalid": invalidValueCollector{},
+	})
+
+	expected := `
+# HELP test_scrape_collector_push_errors_total test_exporter: Number of metrics a collector failed to create, e.g. because of an unconvertible value or a wrong number of label values.
+# TYPE test_scrape_collector_push_errors_total counter
+test_scrape_collector_push_errors_total{collector="invalid"} 2
+# HELP test_scrape_collector_success test_exporter: Whether a collector succeeded.
+# TYPE test_scrape_collector_success gauge
+test_scrape_collector_success{collector="invalid",reason=""} 1
+# HELP test_value Value.
+# TYPE test_value gauge
+test_value{key="valid"} 1
+`
+	if err := testutil.CollectAndCompare(unchecked{collection}, strings.NewReader(expected), "test_scrape_collector_push_errors_total", "test_scrape_collector_success", "test_value"); err != nil {
+		t.Error(err)
+	}
+}
+
+type panickingCollector struct {
+	calls atomic.Int32
+}
+
+func (c *panickingCollector) Update(ch chan<- prometheus.Metric) error {
+	c.calls.Add(1)
+	panic("boom")
+}
+
+func TestCollectionPanicQuarantine(t *testing.T) {
+	t.Parallel()
+	panicking := &panickingCollector{}
+	collection := newTestCollection(t, map[string]Collector{
+		"panicking": panicking,
+		"plain":     &plainCollector{},
+	})
+	collection.statuses["panicking"] = newStatus(options{quarantine: 2})
+
+	for i, reason := range []string{reasonPanic, reasonPanic, reasonQuarantined} {
+		expected := fmt.Sprintf(`
+# HELP test_scrape_collector_success test_exporter: Whether a collector succeeded.
+# TYPE test_scrape_collector_success gauge
+test_scrape_collector_success{collector="panicking",reason=%q} 0
+test_scrape_collector_success{collector="plain",reason=""} 1
+`, reason)
+		if err := testutil.CollectAndCompare(collection, strings.NewReader(expected), "test_scrape_collector_success"); err != nil {
+			t.Errorf("scrape %d: %s", i, err)
+		}
+	}
+	if calls := panicking.calls.Load(); calls != 2 {
+		t.Errorf("quarantined collector was updated %d times, want 2", calls)
+	}
+
+	collection.statuses["panicking"].resetQuarantine()
+	testutil.CollectAndCount(collection, "test_scrape_collector_success")
+	if calls := panicking.calls.Load(); calls != 3 {
+		t.Errorf("collector was updated %d times after lifting the quarantine, want 3", calls)
+	}
+}
+
+type failingCollector struct {
+	calls atomic.Int32
+}
+
+func (c *failingCollector) Update(ch chan<- prometheus.Metric) error {
+	c.calls.Add(1)
+	return ErrUpstreamUnavailable
+}
+
+func TestCollectionCircuitBreaker(t *testing.T) {
+	t.Parallel()
+	failing := &failingCollector{}
+	collection := newTestCollection(t, map[string]Collector{
+		"failing": failing,
+	})
+	s := newStatus(options{breakerFailures: 2, breakerOpenDuration: time.Hour})
+	collection.statuses["failing"] = s
+
+	for i, reason := range []string{reasonUpstreamUnavailable, reasonUpstreamUnavailable, reasonCircuitOpen} {
+		expected := fmt.Sprintf(`
+# HELP test_scrape_collector_success test_exporter: Whether a collector succeeded.
+# TYPE test_scrape_collector_success gauge
+test_scrape_collector_success{collector="failing",reason=%q} 0
+`, reason)
+		if err := testutil.CollectAndCompare(collection, strings.NewReader(expected), "test_scrape_collector_success"); err != nil {
+			t.Errorf("scrape %d: %s", i, err)
+		}
+	}
+	if calls := failing.calls.Load(); calls != 2 {
+		t.Errorf("collector was updated %d times while its circuit was open, want 2", calls)
+	}
+	if state := s.circuitState(); state != circuitOpen {
+		t.Errorf("circuit is %s, want open", state)
+	}
+
+	// The half-open probe fails, so the circuit is opened again for twice as long.
+	// The open duration is moved back instead of waiting for it to elapse.
+	s.mtx.Lock()
+	s.openedAt = s.openedAt.Add(-time.Hour)
+	s.mtx.Unlock()
+	testutil.CollectAndCount(collection, "test_scrape_collector_success")
+	if calls := failing.calls.Load(); calls != 3 {
+		t.Errorf("collector was updated %d times after the open duration, want 3", calls)
+	}
+	if state := s.circuitState(); state != circuitOpen {
+		t.Errorf("circuit is %s after a failed probe, want open", state)
+	}
+	if s.openFor != 2*time.Hour {
+		t.Errorf("circuit is open for %s after a failed probe, want 2h", s.openFor)
+	}
+}
+
+type concurrentCollector struct {
+	running, max *atomic.Int32
+}
+
+func (c concurrentCollector) Update(ch chan<- prometheus.Metric) error {
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		m := c.max.Load()
+		if n <= m || c.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
+
+func TestCollectionConcurrencyGroup(t *testing.T) {
+	t.Parallel()
+	var groupRunning, groupMax, otherRunning, otherMax atomic.Int32
+	collectors := make(map[string]Collector)
+	for i := range 4 {
+		collectors[fmt.Sprintf("db%d", i)] = concurrentCollector{running: &groupRunning, max: &groupMax}
+		collectors[fmt.Sprintf("other%d", i)] = concurrentCollector{running: &otherRunning, max: &otherMax}
+	}
+	collection := newTestCollection(t, collectors)
+	db := make(chan struct{}, 1)
+	for i := range 4 {
+		collection.groups[fmt.Sprintf("db%d", i)] = db
+	}
+
+	testutil.CollectAndCount(collection, "test_scrape_collector_success")
+	if m := groupMax.Load(); m != 1 {
+		t.Errorf("%d collectors of the serialized group ran at once, want 1", m)
+	}
+	if m := otherMax.Load(); m < 2 {
+		t.Errorf("collectors outside the group didn't run in parallel, at most %d ran at once", m)
+	}
+}
+
+type hungCollector struct {
+	unblock chan struct{}
+}
+
+func (c hungCollector) Update(ch chan<- prometheus.Metric) error {
+	<-c.unblock
+	return nil
+}
+
+func TestCollectionConcurrencyHungCollector(t *testing.T) {
+	t.Parallel()
+	hung := hungCollector{unblock: make(chan struct{})}
+	collection := newTestCollection(t, map[string]Collector{"hung": hung})
+	collection.timeouts["hung"] = 10 * time.Millisecond
+	group := make(chan struct{}, 1)
+	collection.groups["hung"] = group
+
+	// The scrape gives up on the collector, but its slot is held until Update returns.
+	testutil.CollectAndCount(collection, "test_scrape_collector_success")
+	if len(group) != 1 {
+		t.Fatal("the slot of the hung collector was released before its update returned")
+	}
+	close(hung.unblock)
+	deadline := time.Now().Add(time.Second)
+	for len(group) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the slot wasn't released after the update returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,171 @@
+// Package collectortest provides utilities to unit-test collectors.
+//
+//	func TestCollectorA(t *testing.T) {
+//		h := collectortest.New(t, collector.DefaultRegistry, "a", "test")
+//		result, err := h.Update()
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		if err := result.CompareGolden("testdata/a.prom", "test_a_m1"); err != nil {
+//			t.Error(err)
+//		}
+//		if err := result.CheckCardinality(100); err != nil {
+//			t.Error(err)
+//		}
+//	}
+package collectortest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/rea1shane/exporter/collector"
+	"github.com/rea1shane/exporter/metric"
+)
+
+// Harness runs a collector in tests, create instances with New.
+type Harness struct {
+	Collector collector.Collector
+	ctx       context.Context // ctx is passed to the collector if it implements collector.ContextCollector
+}
+
+// New creates the collector registered in registry by name, with namespace and a logger which writes through t.Log
+// at debug level. registry defaults to collector.DefaultRegistry. It fails the test if the collector can't be created.
+func New(t testing.TB, registry *collector.Registry, name, namespace string) *Harness {
+	t.Helper()
+	if registry == nil {
+		registry = collector.DefaultRegistry
+	}
+	logger := slog.New(slog.NewTextHandler(testWriter{t}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c, err := registry.NewCollector(name, namespace, logger)
+	if err != nil {
+		t.Fatalf("couldn't create %s collector: %s", name, err)
+	}
+	return &Harness{
+		Collector: c,
+		ctx:       context.Background(),
+	}
+}
+
+// WithContext returns a copy of the harness which updates the collector with ctx.
+func (h Harness) WithContext(ctx context.Context) *Harness {
+	h.ctx = ctx
+	return &h
+}
+
+// Update updates the collector once and returns the pushed metrics along with the error of the update.
+// The result is returned even if the update failed, e.g. to check the metrics of a partial failure.
+func (h *Harness) Update() (*Result, error) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan *Result)
+	go func() {
+		r := &Result{}
+		for m := range ch {
+			if err := metric.PushError(m); err != nil {
+				r.PushErrors = append(r.PushErrors, err)
+				continue
+			}
+			r.Metrics = append(r.Metrics, m)
+		}
+		done <- r
+	}()
+
+	var err error
+	if cc, ok := h.Collector.(collector.ContextCollector); ok {
+		err = cc.UpdateWithContext(h.ctx, ch)
+	} else {
+		err = h.Collector.Update(ch)
+	}
+	close(ch)
+	return <-done, err
+}
+
+// Result holds the metrics pushed by an update.
+type Result struct {
+	Metrics    []prometheus.Metric // Metrics are the valid metrics in the order they were pushed.
+	PushErrors []error             // PushErrors are the errors of the metrics which couldn't be created, see metric.PushError.
+}
+
+// Describe implements prometheus.Collector. It describes nothing, so that the result can be gathered
+// by an unchecked registry even if the collector didn't declare its metrics.
+func (r *Result) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector, it pushes r.Metrics.
+func (r *Result) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range r.Metrics {
+		ch <- m
+	}
+}
+
+// Compare compares the metrics with the expected text exposition, see testutil.CollectAndCompare.
+// Only the metrics named by metricNames are compared, all if none is given.
+func (r *Result) Compare(expected io.Reader, metricNames ...string) error {
+	return testutil.CollectAndCompare(r, expected, metricNames...)
+}
+
+// CompareGolden is like Compare, but reads the expected text exposition from the golden file at path.
+func (r *Result) CompareGolden(path string, metricNames ...string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := r.Compare(f, metricNames...); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// CheckDeclared checks that every metric could be created and was declared by one of descs.
+// A metric is declared by a desc if they have the same fully-qualified name, help, constant and variable labels.
+func (r *Result) CheckDeclared(descs ...*prometheus.Desc) error {
+	declared := make(map[string]bool, len(descs))
+	for _, desc := range descs {
+		declared[desc.String()] = true
+	}
+	errs := slices.Clone(r.PushErrors)
+	for _, m := range r.Metrics {
+		if desc := m.Desc().String(); !declared[desc] {
+			errs = append(errs, fmt.Errorf("undeclared metric: %s", desc))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckCardinality checks that no metric name has more than limit series.
+// The metrics are gathered like in a scrape, so that the errors of the gathering, e.g. duplicate series, are returned too.
+func (r *Result) CheckCardinality(limit int) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(r)
+	mfs, err := registry.Gather()
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, mf := range mfs {
+		if n := len(mf.GetMetric()); n > limit {
+			errs = append(errs, fmt.Errorf("metric %s has %d series, more than the limit of %d", mf.GetName(), n, limit))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// testWriter writes the logs of the collector through t.Log, so that they are shown with the failed tests.
+type testWriter struct {
+	t testing.TB
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,97 @@
+package collectortest
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rea1shane/exporter/collector"
+	"github.com/rea1shane/exporter/metric"
+)
+
+type fakeCollector struct {
+	requestsDesc metric.TypedDesc
+	undeclared   *prometheus.Desc
+}
+
+func (c *fakeCollector) Update(ch chan<- prometheus.Metric) error {
+	c.requestsDesc.PushMetric(ch, 1, "a")
+	c.requestsDesc.PushMetric(ch, 2, "b")
+	c.requestsDesc.PushMetric(ch, "invalid", "c")
+	ch <- prometheus.MustNewConstMetric(c.undeclared, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func newFakeCollector(namespace string, logger *slog.Logger) (collector.Collector, error) {
+	return &fakeCollector{
+		requestsDesc: metric.TypedDesc{
+			Desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, "fake", "requests_total"), "Number of requests.", []string{"host"}, nil),
+			ValueType: prometheus.CounterValue,
+		},
+		undeclared: prometheus.NewDesc(prometheus.BuildFQName(namespace, "fake", "undeclared"), "Undeclared metric.", nil, nil),
+	}, nil
+}
+
+func TestHarness(t *testing.T) {
+	registry := collector.NewRegistry()
+	registry.RegisterCollector("fake", collector.DefaultEnabled, newFakeCollector)
+
+	h := New(t, registry, "fake", "test")
+	result, err := h.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Metrics) != 3 || len(result.PushErrors) != 1 {
+		t.Fatalf("got %d metrics and %d push errors, want 3 and 1", len(result.Metrics), len(result.PushErrors))
+	}
+
+	if err := result.CompareGolden("testdata/fake.prom", "test_fake_requests_total"); err != nil {
+		t.Error(err)
+	}
+	if err := result.Compare(strings.NewReader(""), "test_fake_requests_total"); err == nil {
+		t.Error("expected a mismatch with an empty exposition")
+	}
+
+	c := h.Collector.(*fakeCollector)
+	err = result.CheckDeclared(c.requestsDesc.Desc)
+	if err == nil || !strings.Contains(err.Error(), "test_fake_undeclared") {
+		t.Errorf("expected an undeclared metric error, got %v", err)
+	}
+	if err := (&Result{Metrics: result.Metrics[:2]}).CheckDeclared(c.requestsDesc.Desc); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := result.CheckCardinality(2); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := result.CheckCardinality(1); err == nil || !strings.Contains(err.Error(), "test_fake_requests_total has 2 series") {
+		t.Errorf("expected a cardinality error, got %v", err)
+	}
+}
+
+// recordingTB records the logs written through Log.
+type recordingTB struct {
+	testing.TB
+	logs []string
+}
+
+func (t *recordingTB) Log(args ...any) {
+	t.logs = append(t.logs, fmt.Sprint(args...))
+}
+
+func TestHarnessLogger(t *testing.T) {
+	registry := collector.NewRegistry()
+	registry.RegisterCollector("logging", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		logger.Debug("Creating collector", "namespace", namespace)
+		return newFakeCollector(namespace, logger)
+	})
+
+	tb := &recordingTB{TB: t}
+	New(tb, registry, "logging", "test")
+	if len(tb.logs) != 1 || !strings.Contains(tb.logs[0], `msg="Creating collector" collector=logging namespace=test`) {
+		t.Errorf("unexpected logs: %q", tb.logs)
+	}
+}
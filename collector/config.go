@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configFile is the structure of the configuration file.
+type configFile struct {
+	Collectors map[string]any `yaml:"collectors"` // Collectors contains the sections of the collectors registered WithConfig
+}
+
+// LoadConfig reads the configuration file and reloads every initialized collector implementing Reloadable with its section.
+// The reload is all-or-nothing: if the file is invalid, the current configuration is kept, and if a collector rejects
+// its section, the collectors reloaded before it are rolled back to the current configuration.
+func (r *Registry) LoadConfig(filename string) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	configs, err := r.parseConfig(content)
+	if err != nil {
+		return fmt.Errorf("couldn't parse %s: %w", filename, err)
+	}
+
+	r.initiatedCollectorsMtx.Lock()
+	defer r.initiatedCollectorsMtx.Unlock()
+	previous := r.configs
+	r.configs = configs
+	var reloaded []string
+	for _, name := range slices.Sorted(maps.Keys(r.initiatedCollectors)) {
+		if err := r.reload(name, r.initiatedCollectors[name]); err != nil {
+			r.configs = previous
+			errs := []error{err}
+			for _, name := range reloaded {
+				if err := r.reload(name, r.initiatedCollectors[name]); err != nil {
+					errs = append(errs, fmt.Errorf("couldn't roll back: %w", err))
+				}
+			}
+			return errors.Join(errs...)
+		}
+		reloaded = append(reloaded, name)
+	}
+	return nil
+}
+
+// parseConfig unmarshals the section of every collector registered WithConfig into its configuration.
+func (r *Registry) parseConfig(content []byte) (map[string]any, error) {
+	var file configFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+	for name := range file.Collectors {
+		if r.options[name].newConfig == nil {
+			return nil, fmt.Errorf("unknown collector section: %s", name)
+		}
+	}
+
+	configs := make(map[string]any)
+	for name, o := range r.options {
+		if o.newConfig == nil {
+			continue
+		}
+		config := o.newConfig()
+		if section, ok := file.Collectors[name]; ok {
+			// Unmarshal the section again to get the collector's type.
+			out, err := yaml.Marshal(section)
+			if err != nil {
+				return nil, fmt.Errorf("collector %s: %w", name, err)
+			}
+			if err := yaml.UnmarshalStrict(out, config); err != nil {
+				return nil, fmt.Errorf("collector %s: %w", name, err)
+			}
+		}
+		configs[name] = config
+	}
+	return configs, nil
+}
+
+// reload calls Reload of the collector with its configuration, if it has one and implements Reloadable.
+// Collectors registered WithConfig get their default configuration if no configuration file has been loaded.
+// The caller must hold r.initiatedCollectorsMtx.
+func (r *Registry) reload(name string, collector Collector) error {
+	reloadable, ok := collector.(Reloadable)
+	if !ok {
+		return nil
+	}
+	config, ok := r.configs[name]
+	if !ok {
+		newConfig := r.options[name].newConfig
+		if newConfig == nil {
+			return nil
+		}
+		config = newConfig()
+	}
+	if err := reloadable.Reload(config); err != nil {
+		return fmt.Errorf("couldn't reload %s collector: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promslog"
+)
+
+type testConfig struct {
+	Address string `yaml:"address"`
+	Retries int    `yaml:"retries"`
+}
+
+type reloadableCollector struct {
+	configs []testConfig
+}
+
+func (c *reloadableCollector) Update(ch chan<- prometheus.Metric) error {
+	return nil
+}
+
+func (c *reloadableCollector) Reload(config any) error {
+	c.configs = append(c.configs, *config.(*testConfig))
+	return nil
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+	reloadable := &reloadableCollector{}
+	registry := NewRegistry()
+	registry.RegisterCollector("reloadable", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return reloadable, nil
+	}, WithConfig(func() any {
+		return &testConfig{Address: "localhost:1234", Retries: 3}
+	}))
+	enabled := true
+	registry.collectorState["reloadable"] = &enabled
+
+	if _, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		content string
+		valid   bool
+	}{
+		{"collectors:\n  reloadable:\n    address: example.com:1234\n", true},
+		{"collectors:\n  reloadable:\n    unknown: 1\n", false},
+		{"collectors:\n  unknown:\n    address: example.com:1234\n", false},
+		{"collectors:\n  reloadable:\n    retries: 5\n", true},
+	} {
+		filename := filepath.Join(dir, "config.yml")
+		if err := os.WriteFile(filename, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := registry.LoadConfig(filename); (err == nil) != tc.valid {
+			t.Errorf("LoadConfig(%q) returned error %v, want valid=%v", tc.content, err, tc.valid)
+		}
+	}
+
+	expected := []testConfig{
+		{Address: "localhost:1234", Retries: 3},
+		{Address: "example.com:1234", Retries: 3},
+		{Address: "localhost:1234", Retries: 5},
+	}
+	if len(reloadable.configs) != len(expected) {
+		t.Fatalf("collector was reloaded with %v, want %v", reloadable.configs, expected)
+	}
+	for i := range expected {
+		if reloadable.configs[i] != expected[i] {
+			t.Errorf("reload %d got %v, want %v", i, reloadable.configs[i], expected[i])
+		}
+	}
+}
+
+type rejectingCollector struct {
+	reloadableCollector
+}
+
+func (c *rejectingCollector) Reload(config any) error {
+	if config.(*testConfig).Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+	return c.reloadableCollector.Reload(config)
+}
+
+func TestLoadConfigRollback(t *testing.T) {
+	t.Parallel()
+	accepting, rejecting := &reloadableCollector{}, &rejectingCollector{}
+	registry := NewRegistry()
+	newConfig := WithConfig(func() any { return &testConfig{Address: "localhost:1234"} })
+	registry.RegisterCollector("a", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return accepting, nil
+	}, newConfig)
+	registry.RegisterCollector("b", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return rejecting, nil
+	}, newConfig)
+	for _, name := range []string{"a", "b"} {
+		enabled := true
+		registry.collectorState[name] = &enabled
+	}
+	if _, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	content := "collectors:\n  a:\n    retries: 1\n  b:\n    retries: -1\n"
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.LoadConfig(filename); err == nil {
+		t.Fatal("expected an error for the rejected section")
+	}
+
+	// a accepted the new section, then was rolled back once b rejected its own.
+	expected := []testConfig{
+		{Address: "localhost:1234"},
+		{Address: "localhost:1234", Retries: 1},
+		{Address: "localhost:1234"},
+	}
+	if !slices.Equal(accepting.configs, expected) {
+		t.Errorf("a was reloaded with %v, want %v", accepting.configs, expected)
+	}
+	if len(rejecting.configs) != 1 {
+		t.Errorf("b was reloaded with %v, want only its initial configuration", rejecting.configs)
+	}
+}
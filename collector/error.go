@@ -1,12 +1,143 @@
 package collector
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 )
 
-// ErrNoData indicates the collector found no data to collect, but had no other error.
-var ErrNoData = errors.New("collector returned no data")
+// The errors a collector can return, wrapped or not, to tell why it failed.
+// They are reported in the reason label of the collector_success and collector_errors_total metrics.
+var (
+	ErrNoData              = errors.New("collector returned no data")      // ErrNoData indicates the collector found no data to collect, but had no other error.
+	ErrTimeout             = errors.New("collector timed out")             // ErrTimeout indicates the collector ran out of time.
+	ErrAuth                = errors.New("authentication failed")           // ErrAuth indicates the collector was rejected by the upstream because of its credentials.
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")            // ErrUpstreamUnavailable indicates the upstream couldn't be reached or is failing, usually a transient error.
+	ErrPartial             = errors.New("collector partially failed")      // ErrPartial indicates the collector could only collect a part of its metrics.
+	ErrConfig              = errors.New("invalid collector configuration") // ErrConfig indicates the collector is misconfigured.
+	ErrPanic               = errors.New("collector panicked")              // ErrPanic indicates the collector panicked, see PanicError.
+	ErrQuarantined         = errors.New("collector quarantined")           // ErrQuarantined indicates the collector is not updated anymore because it panicked too often, see WithPanicQuarantine.
+	ErrCircuitOpen         = errors.New("collector circuit open")          // ErrCircuitOpen indicates the collector is skipped because it failed too often, see WithCircuitBreaker.
+)
+
+// The values of the reason label.
+const (
+	reasonNoData              = "no_data"
+	reasonTimeout             = "timeout"
+	reasonAuth                = "auth"
+	reasonUpstreamUnavailable = "upstream_unavailable"
+	reasonPartial             = "partial"
+	reasonConfig              = "config"
+	reasonPanic               = "panic"
+	reasonQuarantined         = "quarantined"
+	reasonCircuitOpen         = "circuit_open"
+	reasonUnknown             = "unknown"
+)
 
 func isNoDataError(err error) bool {
-	return err == ErrNoData
+	return errors.Is(err, ErrNoData)
+}
+
+// updateOutcome returns whether an update which returned err succeeded, a partial failure counts as a success,
+// and the reason of its failure otherwise.
+func updateOutcome(err error) (success bool, reason string) {
+	var partialErr *PartialError
+	if err == nil || errors.As(err, &partialErr) {
+		return true, ""
+	}
+	return false, errorReason(err)
+}
+
+// errorReason classifies err into the value of the reason label.
+func errorReason(err error) string {
+	var timeoutErr interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, ErrNoData):
+		return reasonNoData
+	case errors.Is(err, ErrTimeout),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &timeoutErr) && timeoutErr.Timeout():
+		return reasonTimeout
+	case errors.Is(err, ErrAuth):
+		return reasonAuth
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return reasonUpstreamUnavailable
+	case errors.Is(err, ErrPartial):
+		return reasonPartial
+	case errors.Is(err, ErrConfig):
+		return reasonConfig
+	case errors.Is(err, ErrPanic):
+		return reasonPanic
+	case errors.Is(err, ErrQuarantined):
+		return reasonQuarantined
+	case errors.Is(err, ErrCircuitOpen):
+		return reasonCircuitOpen
+	default:
+		return reasonUnknown
+	}
+}
+
+// SubFailure is the failure of a sub-unit of a collector, e.g. one of the upstream targets it scrapes.
+type SubFailure struct {
+	Key string // Key identifies the sub-unit.
+	Err error  // Err is the reason why the sub-unit failed.
+}
+
+// PartialError is returned by a collector whose sub-units partly failed.
+// The collector is still reported as successful, the failures are logged with their keys
+// and counted in the collector_partial_failures metric.
+//
+//	var partialErr collector.PartialError
+//	for _, target := range targets {
+//		if err := c.scrape(target, ch); err != nil {
+//			partialErr.Add(target, err)
+//		}
+//	}
+//	return partialErr.ErrorOrNil()
+type PartialError struct {
+	Failures []SubFailure
+}
+
+// Add records the failure of the sub-unit identified by key.
+func (e *PartialError) Add(key string, err error) {
+	e.Failures = append(e.Failures, SubFailure{Key: key, Err: err})
+}
+
+// ErrorOrNil returns e if any sub-unit failed, otherwise nil.
+func (e *PartialError) ErrorOrNil() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error implements the error interface.
+func (e *PartialError) Error() string {
+	failures := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		failures = append(failures, fmt.Sprintf("%s: %s", f.Key, f.Err))
+	}
+	return fmt.Sprintf("%s: %s", ErrPartial, strings.Join(failures, "; "))
+}
+
+// Is makes errors.Is(e, ErrPartial) return true.
+func (e *PartialError) Is(target error) bool {
+	return target == ErrPartial
+}
+
+// PanicError is the error of a collector which panicked during its update.
+type PanicError struct {
+	Value any    // Value is the value passed to panic.
+	Stack []byte // Stack is the stack trace of the goroutine which panicked.
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrPanic, e.Value)
+}
+
+// Is makes errors.Is(e, ErrPanic) return true.
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanic
 }
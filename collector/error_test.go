@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestErrorReason(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		err    error
+		reason string
+	}{
+		{ErrNoData, reasonNoData},
+		{fmt.Errorf("query: %w", ErrNoData), reasonNoData},
+		{fmt.Errorf("%w: %w", ErrTimeout, context.DeadlineExceeded), reasonTimeout},
+		{context.DeadlineExceeded, reasonTimeout},
+		{&net.DNSError{Err: "i/o timeout", IsTimeout: true}, reasonTimeout},
+		{fmt.Errorf("login: %w", ErrAuth), reasonAuth},
+		{fmt.Errorf("dial: %w", ErrUpstreamUnavailable), reasonUpstreamUnavailable},
+		{ErrPartial, reasonPartial},
+		{fmt.Errorf("%w: missing DSN", ErrConfig), reasonConfig},
+		{errors.New("something else"), reasonUnknown},
+	} {
+		if reason := errorReason(tc.err); reason != tc.reason {
+			t.Errorf("errorReason(%q) = %q, want %q", tc.err, reason, tc.reason)
+		}
+	}
+}
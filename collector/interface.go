@@ -1,6 +1,8 @@
 package collector
 
 import (
+	"context"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -8,3 +10,22 @@ import (
 type Collector interface {
 	Update(ch chan<- prometheus.Metric) error // Update get new metrics and expose them via prometheus registry.
 }
+
+// ContextCollector is an optional interface a Collector can implement to receive the context of the scrape.
+// When a collector implements it, UpdateWithContext is called instead of Update.
+type ContextCollector interface {
+	UpdateWithContext(ctx context.Context, ch chan<- prometheus.Metric) error // UpdateWithContext is like Update, but ctx is cancelled once the scrape is abandoned.
+}
+
+// Shutdowner is an optional interface a Collector can implement to release its resources (e.g. DB pools) on shutdown.
+// Collectors which implement io.Closer instead are closed on shutdown.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error // Shutdown releases the resources of the collector, it should return once ctx is done.
+}
+
+// Reloadable is an optional interface a Collector registered WithConfig can implement to receive its configuration.
+// Reload is called with the collector's section of the configuration file once the collector is created,
+// and again every time the configuration file is reloaded. It may be called concurrently with Update.
+type Reloadable interface {
+	Reload(config any) error // Reload applies config, which is the value returned by the function given to WithConfig.
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// Option configures a registered collector, see RegisterCollector.
+type Option func(*options)
+
+// options records the configuration of a registered collector.
+type options struct {
+	interval    time.Duration // interval of the background collection, zero means the collector is updated on every scrape
+	description string        // description tells what the collector collects
+	newConfig   func() any    // newConfig creates the collector's configuration with default values, nil means the collector has no configuration
+	quarantine  int           // quarantine is the number of consecutive panics after which the collector is quarantined, zero means never
+
+	breakerFailures     int           // breakerFailures is the number of consecutive failures which open the circuit breaker, zero means no circuit breaker
+	breakerOpenDuration time.Duration // breakerOpenDuration is how long the circuit breaker stays open before it lets an update through again
+
+	concurrencyGroup string // concurrencyGroup is the name of the group whose collectors share concurrencyLimit, empty means no group
+	concurrencyLimit int    // concurrencyLimit is the maximum number of collectors of concurrencyGroup updating at once
+
+	dependencies []string // dependencies are the collectors which are updated before this one in a scrape
+
+	addFlags func(app *kingpin.Application) // addFlags adds the collector's own flags, nil means it has none
+}
+
+// WithInterval makes the collector run in the background every interval instead of on every scrape.
+// Scrapes are served the metrics of its last successful run from a cache.
+// Useful for expensive collectors which take longer than a scrape should.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithConfig declares that the collector has a section in the configuration file, under collectors.<collector name>.
+// newConfig returns a pointer to a new configuration with default values, into which the section is unmarshalled.
+// The collector receives its configuration if it implements Reloadable.
+func WithConfig(newConfig func() any) Option {
+	return func(o *options) {
+		o.newConfig = newConfig
+	}
+}
+
+// WithDescription sets the description of the collector, which is shown in the collector listing.
+func WithDescription(description string) Option {
+	return func(o *options) {
+		o.description = description
+	}
+}
+
+// WithPanicQuarantine quarantines the collector after it panicked in panics consecutive updates.
+// A quarantined collector is not updated anymore and reported as failed with the quarantined reason,
+// until it is enabled again with Registry.SetCollectorState.
+func WithPanicQuarantine(panics int) Option {
+	return func(o *options) {
+		o.quarantine = panics
+	}
+}
+
+// WithCircuitBreaker skips the collector for openDuration after it failed in failures consecutive updates,
+// instead of running it at full cost on every scrape while e.g. its upstream is down.
+// Once openDuration has elapsed, the circuit breaker lets a single update through: if it succeeds the collector
+// is updated on every scrape again, otherwise it is skipped for twice as long as before, up to 32 times openDuration.
+// Skipped updates are reported as failed with the circuit_open reason. Errors wrapping ErrNoData don't count as failures.
+// It doesn't apply to the collectors registered WithInterval, which already run at their own pace.
+func WithCircuitBreaker(failures int, openDuration time.Duration) Option {
+	return func(o *options) {
+		o.breakerFailures = failures
+		o.breakerOpenDuration = openDuration
+	}
+}
+
+// WithConcurrencyGroup puts the collector into a group of collectors of which at most limit are updated at once,
+// across all scrapes, e.g. the collectors querying the same database. A limit of 1 serializes the group.
+// The limit of the first registered collector of the group applies to the whole group.
+// The collectors which are not in a group are only limited by --collector.max-concurrency.
+// Both limits also apply to the background runs of the collectors registered WithInterval.
+func WithConcurrencyGroup(group string, limit int) Option {
+	return func(o *options) {
+		o.concurrencyGroup = group
+		o.concurrencyLimit = max(limit, 1)
+	}
+}
+
+// WithDependencies makes the collector wait in every scrape until the given collectors have been updated,
+// e.g. to derive metrics from the data they fetched with Fetch. The collector is updated even if they failed.
+// Dependencies which are disabled or filtered out of the scrape are ignored, cyclic dependencies make
+// the creation of the collection fail. It doesn't apply to the collectors registered WithInterval.
+func WithDependencies(collectors ...string) Option {
+	return func(o *options) {
+		o.dependencies = collectors
+	}
+}
+
+// WithFlags declares the collector's own flags, addFlags is called by Registry.AddFlags.
+// By convention they are named collector.<collector name>.<flag>.
+func WithFlags(addFlags func(app *kingpin.Application)) Option {
+	return func(o *options) {
+		o.addFlags = addFlags
+	}
+}
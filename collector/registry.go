@@ -1,8 +1,16 @@
 package collector
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 )
@@ -12,28 +20,212 @@ const (
 	DefaultDisabled = false
 )
 
-var (
-	factories        = make(map[string]func(namespace string, logger *slog.Logger) (Collector, error)) // factories records all collector's construction method
-	collectorState   = make(map[string]*bool)                                                          // collectorState records all collector's default state (enabled or disabled)
-	forcedCollectors = map[string]bool{}                                                               // forcedCollectors collectors which have been explicitly enabled or disabled
-)
+// DefaultRegistry is the Registry used by RegisterCollector and DisableDefaultCollectors.
+var DefaultRegistry = NewRegistry()
 
-func RegisterCollector(collector string, isDefaultEnabled bool, factory func(namespace string, logger *slog.Logger) (Collector, error)) {
-	var helpDefaultState string
-	if isDefaultEnabled {
-		helpDefaultState = "enabled"
-	} else {
-		helpDefaultState = "disabled"
+// Factory is the construction method of a collector.
+type Factory func(namespace string, logger *slog.Logger) (Collector, error)
+
+// ProbeFactory is the construction method of a probe collector, it is called for every probe of target with module.
+type ProbeFactory func(target, module, namespace string, logger *slog.Logger) (Collector, error)
+
+// Registry records the registered collectors and their state.
+// Create instances with NewRegistry.
+type Registry struct {
+	factories              map[string]Factory        // factories records all collector's construction method
+	probeFactories         map[string]ProbeFactory   // probeFactories records all probe collector's construction method
+	options                map[string]options        // options records all collector's registration options
+	defaultStates          map[string]bool           // defaultStates records all collector's default state (enabled or disabled)
+	collectorState         map[string]*bool          // collectorState records all collector's state (enabled or disabled), it is filled by AddFlags
+	forcedCollectors       map[string]bool           // forcedCollectors collectors which have been explicitly enabled or disabled
+	collectorTimeouts      map[string]*time.Duration // collectorTimeouts records all collector's timeout, zero means no timeout
+	configs                map[string]any            // configs records the configuration of the collectors registered WithConfig, see LoadConfig
+	statuses               map[string]*status        // statuses records the outcome of all collector's updates
+	concurrencyGroups      map[string]chan struct{}  // concurrencyGroups records the semaphores of the concurrency groups, see WithConcurrencyGroup
+	concurrency            chan struct{}             // concurrency is the semaphore limiting the number of collectors updating at once, nil if unlimited, see SetMaxConcurrency
+	initiatedCollectorsMtx sync.Mutex                // initiatedCollectorsMtx avoid thread conflicts, it also protects the runtime changes of collectorState and configs
+	initiatedCollectors    map[string]Collector      // initiatedCollectors record the collectors that have been initialized in the method NewCollection (To reduce the collector's construction method call)
+}
+
+// NewRegistry creates a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		factories:           make(map[string]Factory),
+		probeFactories:      make(map[string]ProbeFactory),
+		options:             make(map[string]options),
+		defaultStates:       make(map[string]bool),
+		collectorState:      make(map[string]*bool),
+		forcedCollectors:    make(map[string]bool),
+		collectorTimeouts:   make(map[string]*time.Duration),
+		configs:             make(map[string]any),
+		statuses:            make(map[string]*status),
+		concurrencyGroups:   make(map[string]chan struct{}),
+		initiatedCollectors: make(map[string]Collector),
+	}
+}
+
+// RegisterCollector registers a collector to DefaultRegistry.
+func RegisterCollector(collector string, isDefaultEnabled bool, factory Factory, opts ...Option) {
+	DefaultRegistry.RegisterCollector(collector, isDefaultEnabled, factory, opts...)
+}
+
+// RegisterProbeCollector registers a probe collector to DefaultRegistry.
+func RegisterProbeCollector(collector string, isDefaultEnabled bool, factory ProbeFactory, opts ...Option) {
+	DefaultRegistry.RegisterProbeCollector(collector, isDefaultEnabled, factory, opts...)
+}
+
+// DisableDefaultCollectors disables the default collectors of DefaultRegistry, see Registry.DisableDefaultCollectors.
+func DisableDefaultCollectors() {
+	DefaultRegistry.DisableDefaultCollectors()
+}
+
+// RegisterCollector registers a collector, its flags are added by AddFlags.
+func (r *Registry) RegisterCollector(collector string, isDefaultEnabled bool, factory Factory, opts ...Option) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r.defaultStates[collector] = isDefaultEnabled
+	r.factories[collector] = factory
+	r.options[collector] = o
+	r.statuses[collector] = newStatus(o)
+	r.addConcurrencyGroup(o)
+}
+
+// RegisterProbeCollector registers a probe collector, its flags are added by AddFlags.
+// Probe collectors are created for every request to the probe endpoint with its target and module
+// parameters, see NewProbeCollection. Options which run the collector in the background don't apply, neither do
+// WithPanicQuarantine and WithCircuitBreaker, as the outcome of a probe only concerns its target.
+func (r *Registry) RegisterProbeCollector(collector string, isDefaultEnabled bool, factory ProbeFactory, opts ...Option) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r.defaultStates[collector] = isDefaultEnabled
+	r.probeFactories[collector] = factory
+	r.options[collector] = o
+	r.statuses[collector] = newStatus(o)
+	r.addConcurrencyGroup(o)
+}
+
+// addConcurrencyGroup creates the semaphore of the concurrency group of a collector registered with o,
+// unless another collector of the group has been registered before.
+func (r *Registry) addConcurrencyGroup(o options) {
+	if o.concurrencyGroup == "" {
+		return
+	}
+	if _, ok := r.concurrencyGroups[o.concurrencyGroup]; !ok {
+		r.concurrencyGroups[o.concurrencyGroup] = make(chan struct{}, o.concurrencyLimit)
+	}
+}
+
+// collectorDependencies returns the dependencies of collectors, see WithDependencies.
+// Only the dependencies which are in collectors are kept, an error is returned if they are cyclic.
+func (r *Registry) collectorDependencies(collectors map[string]Collector) (map[string][]string, error) {
+	dependencies := make(map[string][]string)
+	for name := range collectors {
+		for _, dependency := range r.options[name].dependencies {
+			if _, ok := collectors[dependency]; ok {
+				dependencies[name] = append(dependencies[name], dependency)
+			}
+		}
+	}
+
+	// Depth-first search, a collector which is visited again while it is on the path closes a cycle.
+	const (
+		unvisited = iota
+		onPath
+		visited
+	)
+	states := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch states[name] {
+		case onPath:
+			return fmt.Errorf("cyclic collector dependencies: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		states[name] = onPath
+		for _, dependency := range dependencies[name] {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		states[name] = visited
+		return nil
+	}
+	for _, name := range slices.Sorted(maps.Keys(collectors)) {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return dependencies, nil
+}
+
+// collectorGroups returns the semaphores of the concurrency groups of collectors.
+func (r *Registry) collectorGroups(collectors map[string]Collector) map[string]chan struct{} {
+	groups := make(map[string]chan struct{})
+	for name := range collectors {
+		if group := r.options[name].concurrencyGroup; group != "" {
+			groups[name] = r.concurrencyGroups[group]
+		}
 	}
+	return groups
+}
+
+// NewCollector creates a new instance of a registered collector with its configuration, e.g. to test it.
+// Unlike NewCollection, it doesn't reuse nor record the initialized collectors, and the collector is neither
+// run in the background nor wrapped by other options. Probe collectors can't be created by name.
+func (r *Registry) NewCollector(name, namespace string, logger *slog.Logger) (Collector, error) {
+	factory, ok := r.factories[name]
+	if !ok {
+		return nil, fmt.Errorf("missing collector: %s", name)
+	}
+	collector, err := factory(namespace, logger.With("collector", name))
+	if err != nil {
+		return nil, err
+	}
+	r.initiatedCollectorsMtx.Lock()
+	defer r.initiatedCollectorsMtx.Unlock()
+	if err := r.reload(name, collector); err != nil {
+		return nil, err
+	}
+	return collector, nil
+}
+
+// HasProbeCollectors returns whether any probe collector is registered.
+func (r *Registry) HasProbeCollectors() bool {
+	return len(r.probeFactories) > 0
+}
+
+// AddFlags adds the flags of all registered collectors to app.
+// It has to be called before app parses the command line.
+func (r *Registry) AddFlags(app *kingpin.Application) {
+	for _, collector := range slices.Sorted(maps.Keys(r.defaultStates)) {
+		isDefaultEnabled := r.defaultStates[collector]
+		var helpDefaultState string
+		if isDefaultEnabled {
+			helpDefaultState = "enabled"
+		} else {
+			helpDefaultState = "disabled"
+		}
 
-	flagName := fmt.Sprintf("collector.%s", collector)
-	flagHelp := fmt.Sprintf("Enable the %s collector (default: %s).", collector, helpDefaultState)
-	defaultValue := fmt.Sprintf("%v", isDefaultEnabled)
+		flagName := fmt.Sprintf("collector.%s", collector)
+		flagHelp := fmt.Sprintf("Enable the %s collector (default: %s).", collector, helpDefaultState)
+		defaultValue := fmt.Sprintf("%v", isDefaultEnabled)
 
-	flag := kingpin.Flag(flagName, flagHelp).Default(defaultValue).Action(collectorFlagAction(collector)).Bool()
-	collectorState[collector] = flag
+		flag := app.Flag(flagName, flagHelp).Default(defaultValue).Action(r.collectorFlagAction(collector)).Bool()
+		r.collectorState[collector] = flag
 
-	factories[collector] = factory
+		timeoutFlagName := fmt.Sprintf("collector.%s.timeout", collector)
+		timeoutFlagHelp := fmt.Sprintf("Timeout of the %s collector, 0 means no timeout other than the scrape timeout.", collector)
+		r.collectorTimeouts[collector] = app.Flag(timeoutFlagName, timeoutFlagHelp).Default("0s").Duration()
+
+		if addFlags := r.options[collector].addFlags; addFlags != nil {
+			addFlags(app)
+		}
+	}
 }
 
 // collectorFlagAction generates a new action function for the given collector
@@ -41,19 +233,118 @@ func RegisterCollector(collector string, isDefaultEnabled bool, factory func(nam
 // A new action function is needed for each collector flag because the ParseContext
 // does not contain information about which flag called the action.
 // See: https://github.com/alecthomas/kingpin/issues/294
-func collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error {
+func (r *Registry) collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error {
 	return func(ctx *kingpin.ParseContext) error {
-		forcedCollectors[collector] = true
+		r.forcedCollectors[collector] = true
 		return nil
 	}
 }
 
+// CollectorState describes the state of a registered collector.
+type CollectorState struct {
+	Name           string `json:"name"`
+	Probe          bool   `json:"probe"`             // Probe is true for the collectors registered by RegisterProbeCollector.
+	DefaultEnabled bool   `json:"default_enabled"`   // DefaultEnabled is the state the collector was registered with.
+	Forced         bool   `json:"forced"`            // Forced is true if the collector has been explicitly enabled or disabled on the command line.
+	Enabled        bool   `json:"enabled"`           // Enabled is the current state of the collector.
+	Quarantined    bool   `json:"quarantined"`       // Quarantined is true if the collector panicked too often, see WithPanicQuarantine.
+	Circuit        string `json:"circuit,omitempty"` // Circuit is the state of the circuit breaker (closed, open or half_open), empty if the collector has none, see WithCircuitBreaker.
+	Description    string `json:"description"`       // Description is given by WithDescription.
+
+	LastDurationSeconds float64    `json:"last_duration_seconds"`  // LastDurationSeconds is the duration of the last update.
+	LastSuccess         *time.Time `json:"last_success,omitempty"` // LastSuccess is the end time of the last successful update, nil if there has been none.
+	LastError           string     `json:"last_error,omitempty"`   // LastError is the error of the last failed update.
+}
+
+// CollectorStates returns the state of all registered collectors, sorted by name.
+// The outcome of the updates only covers the scrapes since the start of the exporter, not the probes.
+func (r *Registry) CollectorStates() []CollectorState {
+	r.initiatedCollectorsMtx.Lock()
+	defer r.initiatedCollectorsMtx.Unlock()
+	states := make([]CollectorState, 0, len(r.defaultStates))
+	for _, collector := range slices.Sorted(maps.Keys(r.defaultStates)) {
+		_, probe := r.probeFactories[collector]
+		state := CollectorState{
+			Name:           collector,
+			Probe:          probe,
+			DefaultEnabled: r.defaultStates[collector],
+			Forced:         r.forcedCollectors[collector],
+			Description:    r.options[collector].description,
+		}
+		if enabled, ok := r.collectorState[collector]; ok {
+			state.Enabled = *enabled
+		}
+		r.statuses[collector].fill(&state)
+		states = append(states, state)
+	}
+	return states
+}
+
+// SetCollectorState enables or disables a collector at runtime.
+// It applies to the collections created afterwards, the collector is kept initialized when it is disabled.
+// The background updates of a collector registered WithInterval are paused while it is disabled.
+// Enabling a collector lifts its quarantine, see WithPanicQuarantine.
+func (r *Registry) SetCollectorState(collector string, enabled bool) error {
+	r.initiatedCollectorsMtx.Lock()
+	defer r.initiatedCollectorsMtx.Unlock()
+	state, ok := r.collectorState[collector]
+	if !ok {
+		return fmt.Errorf("missing collector: %s", collector)
+	}
+	*state = enabled
+	if cached, ok := r.initiatedCollectors[collector].(*cachedCollector); ok {
+		cached.disabled.Store(!enabled)
+	}
+	if s, ok := r.statuses[collector]; ok && enabled {
+		s.resetQuarantine()
+	}
+	return nil
+}
+
 // DisableDefaultCollectors sets the collector state to false for all collectors which
 // have not been explicitly enabled on the command line.
-func DisableDefaultCollectors() {
-	for c := range collectorState {
-		if _, ok := forcedCollectors[c]; !ok {
-			*collectorState[c] = false
+func (r *Registry) DisableDefaultCollectors() {
+	for c := range r.collectorState {
+		if _, ok := r.forcedCollectors[c]; !ok {
+			*r.collectorState[c] = false
 		}
 	}
 }
+
+// SetMaxConcurrency limits the number of collectors updating at once across all scrapes to n, 0 means unlimited.
+// It has to be called before the collections are created. Collectors registered WithInterval are limited
+// in their background runs, not while their cached metrics are served.
+func (r *Registry) SetMaxConcurrency(n int) {
+	r.initiatedCollectorsMtx.Lock()
+	defer r.initiatedCollectorsMtx.Unlock()
+	if n > 0 {
+		r.concurrency = make(chan struct{}, n)
+	} else {
+		r.concurrency = nil
+	}
+}
+
+// ShutdownCollectors shuts down all the collectors which have been initialized.
+// Collectors implementing Shutdowner are shut down with ctx, those implementing io.Closer are closed.
+func (r *Registry) ShutdownCollectors(ctx context.Context) error {
+	r.initiatedCollectorsMtx.Lock()
+	defer r.initiatedCollectorsMtx.Unlock()
+	var errs []error
+	for name, collector := range r.initiatedCollectors {
+		if err := shutdown(ctx, collector); err != nil {
+			errs = append(errs, fmt.Errorf("couldn't shut down %s collector: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shutdown shuts down a collector implementing Shutdowner with ctx, or closes a collector implementing io.Closer.
+func shutdown(ctx context.Context, collector Collector) error {
+	switch c := collector.(type) {
+	case Shutdowner:
+		return c.Shutdown(ctx)
+	case io.Closer:
+		return c.Close()
+	}
+	return nil
+}
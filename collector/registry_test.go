@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promslog"
+)
+
+type shutdownCollector struct {
+	shutdowns *atomic.Int32
+}
+
+func (c shutdownCollector) Update(ch chan<- prometheus.Metric) error {
+	return nil
+}
+
+func (c shutdownCollector) Shutdown(ctx context.Context) error {
+	c.shutdowns.Add(1)
+	return nil
+}
+
+type closerCollector struct {
+	closes *atomic.Int32
+	err    error
+}
+
+func (c closerCollector) Update(ch chan<- prometheus.Metric) error {
+	return nil
+}
+
+func (c closerCollector) Close() error {
+	c.closes.Add(1)
+	return c.err
+}
+
+func TestShutdownCollectors(t *testing.T) {
+	t.Parallel()
+	var shutdowns, closes, cachedCloses atomic.Int32
+	registry := NewRegistry()
+	registry.RegisterCollector("shutdowner", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return shutdownCollector{shutdowns: &shutdowns}, nil
+	})
+	registry.RegisterCollector("closer", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return closerCollector{closes: &closes, err: errors.New("connection reset")}, nil
+	})
+	registry.RegisterCollector("cached", DefaultEnabled, func(string, *slog.Logger) (Collector, error) {
+		return closerCollector{closes: &cachedCloses}, nil
+	}, WithInterval(time.Hour))
+	registry.RegisterCollector("uninitialized", DefaultDisabled, func(string, *slog.Logger) (Collector, error) {
+		t.Error("a disabled collector was created")
+		return nil, nil
+	})
+	for _, name := range []string{"shutdowner", "closer", "cached", "uninitialized"} {
+		enabled := name != "uninitialized"
+		registry.collectorState[name] = &enabled
+	}
+
+	c, err := NewCollection(registry, "test_exporter", "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := c.Collectors["cached"].(*cachedCollector)
+
+	err = registry.ShutdownCollectors(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "couldn't shut down closer collector: connection reset") {
+		t.Errorf("got error %v, want the error of the closer collector", err)
+	}
+	if n := shutdowns.Load(); n != 1 {
+		t.Errorf("Shutdowner was shut down %d times, want 1", n)
+	}
+	if n := closes.Load(); n != 1 {
+		t.Errorf("io.Closer was closed %d times, want 1", n)
+	}
+	if n := cachedCloses.Load(); n != 1 {
+		t.Errorf("cached collector was closed %d times, want 1", n)
+	}
+	select {
+	case <-cached.done:
+	default:
+		t.Error("the background updates of the cached collector weren't stopped")
+	}
+}
@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// scrapeContext is the state shared by the collectors of a single scrape, it is created by Collection.Collect
+// and carried by the context passed to ContextCollector. Use Fetch to share the data of an upstream between collectors.
+type scrapeContext struct {
+	ctx     context.Context // ctx is the context of the scrape, the fetches run with it
+	mtx     sync.Mutex
+	entries map[string]*scrapeEntry // entries records the fetches by key
+}
+
+// scrapeEntry is the result of a fetch, done is closed once value and err are set.
+type scrapeEntry struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+type scrapeContextKey struct{}
+
+// newScrapeContext creates a scrapeContext for the scrape of ctx and returns a context carrying it.
+func newScrapeContext(ctx context.Context) context.Context {
+	sc := &scrapeContext{
+		ctx:     ctx,
+		entries: make(map[string]*scrapeEntry),
+	}
+	return context.WithValue(ctx, scrapeContextKey{}, sc)
+}
+
+// scrapeContextFrom returns the scrapeContext carried by ctx, nil if there is none.
+func scrapeContextFrom(ctx context.Context) *scrapeContext {
+	sc, _ := ctx.Value(scrapeContextKey{}).(*scrapeContext)
+	return sc
+}
+
+// Fetch returns the result of fetch for key, which is called only once per scrape: the other collectors of the scrape
+// which fetch the same key wait for the first call and get the same value and error. The collectors must agree on T.
+// fetch runs with the context of the scrape, so that it isn't cancelled by the timeout of the collector which called it.
+// A panic of fetch is recovered and returned as a PanicError to all the collectors which fetch the key.
+// If ctx is not the context of a scrape, e.g. for a collector registered WithInterval, fetch is simply called with ctx.
+//
+//	func (c *statsCollector) UpdateWithContext(ctx context.Context, ch chan<- prometheus.Metric) error {
+//		stats, err := collector.Fetch(ctx, "upstream/stats", c.client.Stats)
+//		...
+//	}
+func Fetch[T any](ctx context.Context, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	sc := scrapeContextFrom(ctx)
+	if sc == nil {
+		return fetch(ctx)
+	}
+
+	sc.mtx.Lock()
+	entry, ok := sc.entries[key]
+	if !ok {
+		entry = &scrapeEntry{done: make(chan struct{})}
+		sc.entries[key] = entry
+	}
+	sc.mtx.Unlock()
+
+	if !ok {
+		func() {
+			defer close(entry.done)
+			defer func() {
+				if r := recover(); r != nil {
+					entry.err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			entry.value, entry.err = fetch(sc.ctx)
+		}()
+	}
+
+	var zero T
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	if entry.err != nil {
+		return zero, entry.err
+	}
+	value, ok := entry.value.(T)
+	if !ok {
+		return zero, fmt.Errorf("fetched %s as %T, not %T", key, entry.value, zero)
+	}
+	return value, nil
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fetchingCollector struct {
+	fetches *atomic.Int32
+	got     *atomic.Int32
+}
+
+func (c fetchingCollector) Update(ch chan<- prometheus.Metric) error {
+	return c.UpdateWithContext(context.Background(), ch)
+}
+
+func (c fetchingCollector) UpdateWithContext(ctx context.Context, ch chan<- prometheus.Metric) error {
+	value, err := Fetch(ctx, "upstream", func(ctx context.Context) (int32, error) {
+		return c.fetches.Add(1), nil
+	})
+	if err != nil {
+		return err
+	}
+	c.got.Add(value)
+	return nil
+}
+
+func TestFetchSharedPerScrape(t *testing.T) {
+	t.Parallel()
+	var fetches, got atomic.Int32
+	collection := newTestCollection(t, map[string]Collector{
+		"a": fetchingCollector{fetches: &fetches, got: &got},
+		"b": fetchingCollector{fetches: &fetches, got: &got},
+		"c": fetchingCollector{fetches: &fetches, got: &got},
+	})
+
+	testutil.CollectAndCount(collection, "test_scrape_collector_success")
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("upstream was fetched %d times in a scrape, want 1", n)
+	}
+	if n := got.Load(); n != 3 {
+		t.Errorf("collectors got a sum of %d, want 3 as they all got the first fetch", n)
+	}
+
+	testutil.CollectAndCount(collection, "test_scrape_collector_success")
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("upstream was fetched %d times in two scrapes, want 2", n)
+	}
+}
+
+type orderedCollector struct {
+	name  string
+	order chan<- string
+}
+
+func (c orderedCollector) Update(ch chan<- prometheus.Metric) error {
+	c.order <- c.name
+	return nil
+}
+
+func TestCollectionDependencies(t *testing.T) {
+	t.Parallel()
+	order := make(chan string, 3)
+	collection := newTestCollection(t, map[string]Collector{
+		"derived": orderedCollector{name: "derived", order: order},
+		"middle":  orderedCollector{name: "middle", order: order},
+		"base":    orderedCollector{name: "base", order: order},
+	})
+	collection.dependencies = map[string][]string{
+		"derived": {"middle"},
+		"middle":  {"base"},
+	}
+
+	testutil.CollectAndCount(collection, "test_scrape_collector_success")
+	close(order)
+	var got []string
+	for name := range order {
+		got = append(got, name)
+	}
+	if strings.Join(got, ",") != "base,middle,derived" {
+		t.Errorf("collectors were updated in the order %v, want [base middle derived]", got)
+	}
+}
+
+func TestCollectorDependenciesCycle(t *testing.T) {
+	t.Parallel()
+	registry := NewRegistry()
+	registry.options["a"] = options{dependencies: []string{"b"}}
+	registry.options["b"] = options{dependencies: []string{"c", "disabled"}}
+	registry.options["c"] = options{dependencies: []string{"a"}}
+	collectors := map[string]Collector{"a": &plainCollector{}, "b": &plainCollector{}, "c": &plainCollector{}}
+
+	_, err := registry.collectorDependencies(collectors)
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+
+	delete(collectors, "c")
+	dependencies, err := registry.collectorDependencies(collectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dependencies["b"]) != 0 {
+		t.Errorf("dependencies missing from the collection are kept: %v", dependencies["b"])
+	}
+}
+
+func TestFetchPanic(t *testing.T) {
+	t.Parallel()
+	ctx := newScrapeContext(context.Background())
+	started, release := make(chan struct{}), make(chan struct{})
+	errs := make(chan error, 3)
+	go func() {
+		_, err := Fetch(ctx, "upstream", func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			panic("fetch failed")
+		})
+		errs <- err
+	}()
+	<-started
+	for range 2 {
+		go func() {
+			_, err := Fetch(ctx, "upstream", func(ctx context.Context) (int, error) {
+				t.Error("fetch was called again for the same key")
+				return 0, nil
+			})
+			errs <- err
+		}()
+	}
+	close(release)
+
+	for range 3 {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrPanic) {
+				t.Errorf("got error %v, want a PanicError", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("a fetch of the key is still blocked after the panic")
+		}
+	}
+}
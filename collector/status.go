@@ -0,0 +1,197 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// status records the outcome of the updates of a registered collector across scrapes.
+type status struct {
+	mtx          sync.Mutex
+	errors       map[string]float64 // errors counts the failed updates by reason
+	lastDuration time.Duration      // lastDuration is the duration of the last update
+	lastSuccess  time.Time          // lastSuccess is the end time of the last successful update
+	lastError    error              // lastError is the error of the last failed update
+	pushErrors   float64            // pushErrors counts the metrics which couldn't be created, see metric.PushError
+
+	quarantineThreshold int  // quarantineThreshold is the number of consecutive panics after which the collector is quarantined, zero means never
+	panics              int  // panics counts the consecutive updates which panicked
+	quarantined         bool // quarantined is true once panics reached quarantineThreshold
+
+	breakerThreshold    int           // breakerThreshold is the number of consecutive failures which open the circuit, zero means the collector has no circuit breaker
+	breakerOpenDuration time.Duration // breakerOpenDuration is how long the circuit stays open after it has been opened by breakerThreshold failures
+	failures            int           // failures counts the consecutive failed updates
+	circuit             circuitState  // circuit is the state of the circuit breaker
+	openedAt            time.Time     // openedAt is the time the circuit was opened
+	openFor             time.Duration // openFor is how long the circuit stays open, it is doubled every time the half-open probe fails
+}
+
+// newStatus creates the status of a collector registered with o.
+func newStatus(o options) *status {
+	s := &status{
+		errors:              make(map[string]float64),
+		quarantineThreshold: o.quarantine,
+	}
+	if o.interval <= 0 {
+		s.breakerThreshold = o.breakerFailures
+		s.breakerOpenDuration = o.breakerOpenDuration
+		s.openFor = o.breakerOpenDuration
+	}
+	return s
+}
+
+// circuitState is the state of the circuit breaker of a collector, see WithCircuitBreaker.
+// Its value is exposed by the collector_circuit_state metric.
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // circuitClosed lets every update through
+	circuitOpen                         // circuitOpen skips the updates
+	circuitHalfOpen                     // circuitHalfOpen lets one update through to probe whether the collector recovered
+)
+
+// maxOpenDurationFactor caps the backoff of the circuit breaker to this multiple of its open duration.
+const maxOpenDurationFactor = 32
+
+// String returns the name of the state, as shown in the collector listing.
+func (c circuitState) String() string {
+	switch c {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// record records the outcome of an update, reason is only used if it failed.
+func (s *status) record(duration time.Duration, success bool, reason string, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.lastDuration = duration
+	if reason == reasonPanic {
+		s.panics++
+		if s.quarantineThreshold > 0 && s.panics >= s.quarantineThreshold {
+			s.quarantined = true
+		}
+	} else if reason != reasonQuarantined {
+		s.panics = 0
+	}
+	if s.breakerThreshold > 0 && reason != reasonQuarantined && reason != reasonCircuitOpen {
+		s.recordCircuit(success || reason == reasonNoData)
+	}
+	if success {
+		s.lastSuccess = time.Now()
+	} else {
+		s.errors[reason]++
+		s.lastError = err
+	}
+}
+
+// recordCircuit moves the circuit breaker according to the outcome of an update, s.mtx has to be held.
+// A failed probe in the half-open state opens the circuit again for twice as long, up to maxOpenDurationFactor
+// times the open duration.
+func (s *status) recordCircuit(success bool) {
+	if success {
+		s.failures = 0
+		s.circuit = circuitClosed
+		s.openFor = s.breakerOpenDuration
+		return
+	}
+	s.failures++
+	switch {
+	case s.circuit == circuitHalfOpen:
+		s.openFor = min(2*s.openFor, maxOpenDurationFactor*s.breakerOpenDuration)
+		s.circuit = circuitOpen
+		s.openedAt = time.Now()
+	case s.failures >= s.breakerThreshold:
+		s.circuit = circuitOpen
+		s.openedAt = time.Now()
+	}
+}
+
+// allowUpdate returns whether the circuit breaker lets an update through.
+// Once the circuit has been open long enough, it turns half-open and lets a single update through.
+func (s *status) allowUpdate() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch s.circuit {
+	case circuitOpen:
+		if time.Since(s.openedAt) < s.openFor {
+			return false
+		}
+		s.circuit = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// Another update is probing the collector.
+		return false
+	default:
+		return true
+	}
+}
+
+// circuitState returns the state of the circuit breaker.
+func (s *status) circuitState() circuitState {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.circuit
+}
+
+// errorCounts returns a copy of the failed updates counted by reason.
+func (s *status) errorCounts() map[string]float64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	counts := make(map[string]float64, len(s.errors))
+	for reason, count := range s.errors {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// isQuarantined returns whether the collector panicked in too many consecutive updates.
+func (s *status) isQuarantined() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.quarantined
+}
+
+// resetQuarantine lifts the quarantine of the collector.
+func (s *status) resetQuarantine() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.panics = 0
+	s.quarantined = false
+}
+
+// recordPushErrors records n metrics which couldn't be created.
+func (s *status) recordPushErrors(n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.pushErrors += float64(n)
+}
+
+// pushErrorCount returns the number of metrics which couldn't be created.
+func (s *status) pushErrorCount() float64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.pushErrors
+}
+
+// fill sets the fields of state which are recorded by s.
+func (s *status) fill(state *CollectorState) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	state.LastDurationSeconds = s.lastDuration.Seconds()
+	state.Quarantined = s.quarantined
+	if s.breakerThreshold > 0 {
+		state.Circuit = s.circuit.String()
+	}
+	if !s.lastSuccess.IsZero() {
+		lastSuccess := s.lastSuccess
+		state.LastSuccess = &lastSuccess
+	}
+	if s.lastError != nil {
+		state.LastError = s.lastError.Error()
+	}
+}
@@ -0,0 +1,218 @@
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/rea1shane/exporter/metric"
+)
+
+func init() {
+	// directory is set by the --collector.textfile.directory flag, it is nil until the flags are added.
+	var directory *string
+	RegisterCollector("textfile", DefaultDisabled,
+		func(namespace string, logger *slog.Logger) (Collector, error) {
+			if directory == nil {
+				return NewTextfileCollector("", namespace, logger)
+			}
+			return NewTextfileCollector(*directory, namespace, logger)
+		},
+		WithDescription("Exposes the metrics of the *.prom files in --collector.textfile.directory, like node_exporter's textfile collector."),
+		WithFlags(func(app *kingpin.Application) {
+			directory = app.Flag(
+				"collector.textfile.directory",
+				"Directory to read the *.prom text files with metrics from.",
+			).Default("").String()
+		}),
+	)
+}
+
+// textfileCollector exposes the metrics of the *.prom files in a directory, in the text exposition format.
+// Create instances with NewTextfileCollector.
+type textfileCollector struct {
+	directory string
+	mtimeDesc metric.TypedDesc
+	errorDesc metric.TypedDesc
+}
+
+// NewTextfileCollector creates a textfile collector which reads directory,
+// the registered one reads the directory given by --collector.textfile.directory.
+func NewTextfileCollector(directory, namespace string, logger *slog.Logger) (Collector, error) {
+	return &textfileCollector{
+		directory: directory,
+		mtimeDesc: metric.TypedDesc{
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "textfile", "mtime_seconds"),
+				"Unixtime mtime of textfiles successfully read.",
+				[]string{"file"},
+				nil,
+			),
+			ValueType: prometheus.GaugeValue,
+		},
+		errorDesc: metric.TypedDesc{
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "textfile", "scrape_error"),
+				"1 if there was an error opening or reading a file, 0 otherwise.",
+				nil,
+				nil,
+			),
+			ValueType: prometheus.GaugeValue,
+		},
+	}, nil
+}
+
+// Update implements Collector.
+// A file which can't be read or parsed is skipped and reported in the returned PartialError with its name as key.
+func (c *textfileCollector) Update(ch chan<- prometheus.Metric) error {
+	if c.directory == "" {
+		return fmt.Errorf("%w: --collector.textfile.directory is not set", ErrConfig)
+	}
+	paths, err := filepath.Glob(filepath.Join(c.directory, "*.prom"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+	var (
+		partialErr PartialError
+		families   = make(map[string]*dto.MetricFamily) // families merges the metric families of all files by name
+	)
+	for _, path := range paths {
+		file := filepath.Base(path)
+		mtime, fileFamilies, err := c.parseFile(path)
+		if err == nil {
+			err = mergeFamilies(families, fileFamilies)
+		}
+		if err != nil {
+			partialErr.Add(file, err)
+			continue
+		}
+		c.mtimeDesc.PushMetric(ch, mtime, file)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(families)) {
+		pushFamily(ch, families[name])
+	}
+
+	var scrapeError float64
+	if len(partialErr.Failures) > 0 {
+		scrapeError = 1
+	}
+	c.errorDesc.PushMetric(ch, scrapeError)
+	return partialErr.ErrorOrNil()
+}
+
+// parseFile parses a text file and returns its mtime in seconds with its metric families.
+func (c *textfileCollector) parseFile(path string) (float64, map[string]*dto.MetricFamily, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("malformed file: %w", err)
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if m.TimestampMs != nil {
+				return 0, nil, fmt.Errorf("metric %s has a client-side timestamp, which is not supported", mf.GetName())
+			}
+		}
+		if mf.Help == nil {
+			help := fmt.Sprintf("Metric read from %s", path)
+			mf.Help = &help
+		}
+	}
+
+	// Only stat the file once it has been parsed successfully, so that its mtime isn't exposed otherwise.
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	return float64(info.ModTime().UnixNano()) / 1e9, families, nil
+}
+
+// mergeFamilies merges the families of a file into families.
+// The families of the file are not merged at all if one of them has another type than in a previous file.
+func mergeFamilies(families, fileFamilies map[string]*dto.MetricFamily) error {
+	for name, mf := range fileFamilies {
+		if existing, ok := families[name]; ok && existing.GetType() != mf.GetType() {
+			return fmt.Errorf("metric %s is a %s, but a %s in another file", name, mf.GetType(), existing.GetType())
+		}
+	}
+	for name, mf := range fileFamilies {
+		if existing, ok := families[name]; ok {
+			existing.Metric = append(existing.Metric, mf.Metric...)
+		} else {
+			families[name] = mf
+		}
+	}
+	return nil
+}
+
+// pushFamily pushes the metrics of a parsed metric family. All the metrics of the family are given the
+// union of their label names, missing labels are set to the empty value.
+func pushFamily(ch chan<- prometheus.Metric, mf *dto.MetricFamily) {
+	labelNames := make(map[string]bool)
+	for _, m := range mf.GetMetric() {
+		for _, lp := range m.GetLabel() {
+			labelNames[lp.GetName()] = true
+		}
+	}
+	names := slices.Sorted(maps.Keys(labelNames))
+	desc := prometheus.NewDesc(mf.GetName(), mf.GetHelp(), names, nil)
+
+	for _, m := range mf.GetMetric() {
+		values := make(map[string]string, len(names))
+		for _, lp := range m.GetLabel() {
+			values[lp.GetName()] = lp.GetValue()
+		}
+		labelValues := make([]string, 0, len(names))
+		for _, name := range names {
+			labelValues = append(labelValues, values[name])
+		}
+
+		var (
+			pm  prometheus.Metric
+			err error
+		)
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			pm, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), labelValues...)
+		case dto.MetricType_GAUGE:
+			pm, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), labelValues...)
+		case dto.MetricType_UNTYPED:
+			pm, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), labelValues...)
+		case dto.MetricType_SUMMARY:
+			quantiles := make(map[float64]float64)
+			for _, q := range m.GetSummary().GetQuantile() {
+				quantiles[q.GetQuantile()] = q.GetValue()
+			}
+			pm, err = prometheus.NewConstSummary(desc, m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(), quantiles, labelValues...)
+		case dto.MetricType_HISTOGRAM:
+			buckets := make(map[float64]uint64)
+			for _, b := range m.GetHistogram().GetBucket() {
+				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+			}
+			pm, err = prometheus.NewConstHistogram(desc, m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(), buckets, labelValues...)
+		default:
+			err = fmt.Errorf("unsupported metric type %s", strings.ToLower(mf.GetType().String()))
+		}
+		if err != nil {
+			ch <- metric.NewInvalidMetric(desc, err)
+			continue
+		}
+		ch <- pm
+	}
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestTextfileCollector(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	for name, content := range map[string]string{
+		"jobs.prom": `# HELP backup_last_success_seconds Last successful backup.
+# TYPE backup_last_success_seconds gauge
+backup_last_success_seconds{job="db"} 1.7e+09
+`,
+		"more.prom": `# TYPE backup_last_success_seconds gauge
+backup_last_success_seconds{job="files",host="a"} 1.6e+09
+`,
+		"malformed.prom": `backup_last_success_seconds{job="broken" 1
+`,
+		"other.prom": `# TYPE backup_last_success_seconds counter
+backup_last_success_seconds 1
+`,
+		"ignored.txt": `ignored 1
+`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := NewTextfileCollector(dir, "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := newTestCollection(t, map[string]Collector{"textfile": c})
+
+	expected := `
+# HELP backup_last_success_seconds Last successful backup.
+# TYPE backup_last_success_seconds gauge
+backup_last_success_seconds{host="",job="db"} 1.7e+09
+backup_last_success_seconds{host="a",job="files"} 1.6e+09
+# HELP test_scrape_collector_partial_failures test_exporter: Number of failed sub-units of a collector which partially succeeded.
+# TYPE test_scrape_collector_partial_failures gauge
+test_scrape_collector_partial_failures{collector="textfile"} 2
+# HELP test_textfile_mtime_seconds Unixtime mtime of textfiles successfully read.
+# TYPE test_textfile_mtime_seconds gauge
+test_textfile_mtime_seconds{file="jobs.prom"} 1.7e+09
+test_textfile_mtime_seconds{file="more.prom"} 1.7e+09
+# HELP test_textfile_scrape_error 1 if there was an error opening or reading a file, 0 otherwise.
+# TYPE test_textfile_scrape_error gauge
+test_textfile_scrape_error 1
+`
+	if err := testutil.CollectAndCompare(unchecked{collection}, strings.NewReader(expected),
+		"backup_last_success_seconds", "test_scrape_collector_partial_failures", "test_textfile_mtime_seconds", "test_textfile_scrape_error"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTextfileCollectorFlag(t *testing.T) {
+	app := kingpin.New("test_exporter", "")
+	DefaultRegistry.AddFlags(app)
+	if _, err := app.Parse([]string{"--collector.textfile.directory=/tmp/textfile"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := DefaultRegistry.NewCollector("textfile", "test", promslog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if directory := c.(*textfileCollector).directory; directory != "/tmp/textfile" {
+		t.Errorf("directory is %q, want the one of the flag", directory)
+	}
+}
@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+var collectorsTemplate = template.Must(template.New("collectors").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; }
+th { background-color: #f5f5f5; }
+.disabled { color: #999; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p><a href="?format=json">JSON</a></p>
+<table>
+<tr><th>Name</th><th>Enabled</th><th>Default</th><th>Last duration</th><th>Last success</th><th>Last error</th><th>Description</th></tr>
+{{- range .Collectors }}
+<tr{{ if not .Enabled }} class="disabled"{{ end }}>
+<td>{{ .Name }}{{ if .Probe }} (probe){{ end }}</td>
+<td>{{ .Enabled }}{{ if .Quarantined }} (quarantined){{ end }}{{ if and .Circuit (ne .Circuit "closed") }} (circuit {{ .Circuit }}){{ end }}</td>
+<td>{{ if .DefaultEnabled }}enabled{{ else }}disabled{{ end }}</td>
+<td>{{ printf "%.3fs" .LastDurationSeconds }}</td>
+<td>{{ with .LastSuccess }}{{ .Format "2006-01-02T15:04:05Z07:00" }}{{ else }}never{{ end }}</td>
+<td class="error">{{ .LastError }}</td>
+<td>{{ .Description }}</td>
+</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// collectorsHandler lists the registered collectors as HTML, or as JSON if
+// requested by the format=json parameter or the Accept header. Create
+// instances with newCollectorsHandler.
+type collectorsHandler struct {
+	registry *collector.Registry
+	title    string
+	logger   *slog.Logger
+}
+
+func newCollectorsHandler(registry *collector.Registry, title string, logger *slog.Logger) *collectorsHandler {
+	return &collectorsHandler{
+		registry: registry,
+		title:    title,
+		logger:   logger,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *collectorsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	states := h.registry.CollectorStates()
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(states); err != nil {
+			h.logger.Error("Couldn't encode collector states", "err", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	if err := collectorsTemplate.Execute(w, struct {
+		Title      string
+		Collectors []collector.CollectorState
+	}{
+		Title:      h.title,
+		Collectors: states,
+	}); err != nil {
+		h.logger.Error("Couldn't render collectors page", "err", err)
+	}
+}
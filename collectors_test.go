@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+func TestCollectorsPage(t *testing.T) {
+	t.Parallel()
+	e, err := New(Options{
+		SnakeCaseName:     "test_exporter",
+		Namespace:         "test",
+		LandingPageConfig: LandingPageConfig{TitleCaseName: "Test Exporter"},
+		Registry:          newTestRegistry(),
+		Args:              []string{"--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+
+	rec := httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/collectors?format=json", nil))
+	var states []collector.CollectorState
+	if err := json.NewDecoder(rec.Body).Decode(&states); err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 {
+		t.Fatalf("got %d collectors, want 1", len(states))
+	}
+	if s := states[0]; s.Name != "test" || !s.Enabled || s.Description != "Collects test metrics." || s.LastSuccess == nil || s.LastError != "" {
+		t.Errorf("unexpected collector state %+v", s)
+	}
+
+	rec = httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/collectors", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{"<title>Test Exporter Collectors</title>", "<td>test</td>", "<td>Collects test metrics.</td>"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("page doesn't contain %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if body, _ := io.ReadAll(rec.Body); !strings.Contains(string(body), `href="/collectors"`) {
+		t.Errorf("landing page doesn't link the collectors page:\n%s", body)
+	}
+}
@@ -1,13 +1,22 @@
 package exporter
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"os/user"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
@@ -31,53 +40,226 @@ type LandingPageConfig struct {
 	ExtraCSS      string             // ExtraCSS is additional CSS to be embedded.
 }
 
-// Run will start the exporter.
-// snakeCaseName is exporter name in snake case. For example: node_exporter.
-func Run(snakeCaseName, namespace, defaultAddress string, landingPageConfig LandingPageConfig, warningRunAsRoot bool) {
+// Options configures an Exporter.
+type Options struct {
+	SnakeCaseName     string               // SnakeCaseName is exporter name in snake case. For example: node_exporter.
+	Namespace         string               // Namespace defines the common namespace to be used by all metrics.
+	DefaultAddress    string               // DefaultAddress is the default value of the --web.listen-address flag.
+	LandingPageConfig LandingPageConfig    // LandingPageConfig configures the landing page.
+	WarningRunAsRoot  bool                 // WarningRunAsRoot logs a warning if the exporter is running as root user.
+	Registry          *collector.Registry  // Registry contains the collectors of the exporter. If nil, collector.DefaultRegistry is used.
+	Application       *kingpin.Application // Application parses the command line flags. If nil, a new kingpin.Application is created.
+	Args              []string             // Args are the command line arguments without the program name. For example: os.Args[1:].
+}
+
+// Exporter serves the metrics of the registered collectors.
+// Create instances with New.
+type Exporter struct {
+	registry        *collector.Registry
+	snakeCaseName   string
+	namespace       string
+	checkConfig     bool // checkConfig makes Start run Check instead of serving, see the --check-config flag
+	checkAtStartup  bool // checkAtStartup makes Start run Check and log its report before serving, see the --check-at-startup flag
+	maxProcs        int  // maxProcs is set by the --runtime.gomaxprocs flag, it is only applied by Run
+	configFile      string
+	configSuccess   prometheus.Gauge
+	configSuccessTS prometheus.Gauge
+	mux             *http.ServeMux
+	server          *http.Server
+	toolkitFlags    *web.FlagConfig
+	pusher          *pusher // pusher pushes the metrics if the --push.url flag is set, otherwise nil
+	shutdownTimeout time.Duration
+	logger          *slog.Logger
+}
+
+// New parses the command line flags in opts.Args and creates an Exporter.
+// It doesn't change the process-wide settings of the Go runtime, the --runtime.gomaxprocs flag is only applied by Run.
+func New(opts Options) (*Exporter, error) {
+	app := opts.Application
+	if app == nil {
+		app = kingpin.New(opts.SnakeCaseName, "")
+	}
+	// The hostname is the default instance of the pushed metrics, it is left empty if it can't be determined.
+	hostname, _ := os.Hostname()
 	var (
-		metricsPath = kingpin.Flag(
+		metricsPath = app.Flag(
 			"web.telemetry-path",
 			"Path under which to expose metrics.",
 		).Default("/metrics").String()
-		disableExporterMetrics = kingpin.Flag(
+		configFile = app.Flag(
+			"config.file",
+			"Path to the configuration file of the collectors.",
+		).String()
+		enableLifecycle = app.Flag(
+			"web.enable-lifecycle",
+			"Enable reloading the configuration file via HTTP POST to /-/reload. It is reloaded on SIGHUP regardless.",
+		).Bool()
+		adminTokenFile = app.Flag(
+			"web.admin-token-file",
+			"Path to a file containing the bearer token of the admin API. The admin API is disabled if not set.",
+		).String()
+		collectorsPath = app.Flag(
+			"web.collectors-path",
+			"Path under which to list the collectors.",
+		).Default("/collectors").String()
+		probePath = app.Flag(
+			"web.probe-path",
+			"Path under which to expose the metrics of the probe collectors.",
+		).Default("/probe").String()
+		disableExporterMetrics = app.Flag(
 			"web.disable-exporter-metrics",
 			"Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).",
 		).Bool()
-		maxRequests = kingpin.Flag(
+		maxRequests = app.Flag(
 			"web.max-requests",
 			"Maximum number of parallel scrape requests. Use 0 to disable.",
 		).Default("40").Int()
-		disableDefaultCollectors = kingpin.Flag(
+		enableOpenMetrics = app.Flag(
+			"web.enable-openmetrics",
+			"Enable the OpenMetrics exposition format, which exposes exemplars and created timestamps.",
+		).Bool()
+		coalesceScrapes = app.Flag(
+			"web.coalesce-scrapes",
+			"Let concurrent scrapes with the same collect[] or exclude[] filters share a single collection, e.g. for HA Prometheus pairs.",
+		).Bool()
+		timeoutOffset = app.Flag(
+			"web.timeout-offset",
+			"Offset to subtract from the scrape timeout sent by Prometheus.",
+		).Default("500ms").Duration()
+		disableDefaultCollectors = app.Flag(
 			"collector.disable-defaults",
 			"Set all collectors to disabled by default.",
 		).Default("false").Bool()
-		maxProcs = kingpin.Flag(
+		maxConcurrency = app.Flag(
+			"collector.max-concurrency",
+			"Maximum number of collectors updating at once across all scrapes. Use 0 to disable.",
+		).Default("0").Int()
+		shutdownTimeout = app.Flag(
+			"web.shutdown-timeout",
+			"Maximum time to wait for in-flight scrapes and collectors on shutdown.",
+		).Default("30s").Duration()
+		pushURL = app.Flag(
+			"push.url",
+			"URL of the Pushgateway or remote-write endpoint to push the metrics to, for hosts Prometheus can't reach. Push mode is disabled if not set.",
+		).String()
+		pushInterval = app.Flag(
+			"push.interval",
+			"Interval between two pushes.",
+		).Default("1m").Duration()
+		pushFormat = app.Flag(
+			"push.format",
+			"Protocol of the push endpoint, one of: [pushgateway, remote-write].",
+		).Default(pushFormatPushgateway).Enum(pushFormatPushgateway, pushFormatRemoteWrite)
+		pushInstance = app.Flag(
+			"push.instance",
+			"Value of the instance label of the pushed metrics, used as a grouping key by the Pushgateway. No instance label is set if empty.",
+		).Default(hostname).String()
+		checkConfig = app.Flag(
+			"check-config",
+			"Update every enabled collector once, check its metrics with promlint and for consistency, print a report and exit instead of serving. The exit status is non-zero if a problem is found.",
+		).Bool()
+		checkAtStartup = app.Flag(
+			"check-at-startup",
+			"Run the checks of --check-config once at startup and log the problems found as warnings, then serve anyway.",
+		).Bool()
+		maxProcs = app.Flag(
 			"runtime.gomaxprocs", "The target number of CPUs Go will run on (GOMAXPROCS)",
 		).Envar("GOMAXPROCS").Default("1").Int()
-		toolkitFlags = kingpinflag.AddFlags(kingpin.CommandLine, defaultAddress)
+		toolkitFlags = kingpinflag.AddFlags(app, opts.DefaultAddress)
 	)
+	registry := opts.Registry
+	if registry == nil {
+		registry = collector.DefaultRegistry
+	}
+	registry.AddFlags(app)
 
 	promslogConfig := &promslog.Config{}
-	flag.AddFlags(kingpin.CommandLine, promslogConfig)
-	kingpin.Version(version.Print(snakeCaseName))
-	kingpin.CommandLine.UsageWriter(os.Stdout)
-	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	flag.AddFlags(app, promslogConfig)
+	app.Version(version.Print(opts.SnakeCaseName))
+	app.UsageWriter(os.Stdout)
+	app.HelpFlag.Short('h')
+	if _, err := app.Parse(opts.Args); err != nil {
+		return nil, err
+	}
 	logger := promslog.New(promslogConfig)
 
 	if *disableDefaultCollectors {
-		collector.DisableDefaultCollectors()
+		registry.DisableDefaultCollectors()
 	}
-	logger.Info(fmt.Sprintf("Starting %s", snakeCaseName), "version", version.Info())
+	registry.SetMaxConcurrency(*maxConcurrency)
+	logger.Info(fmt.Sprintf("Starting %s", opts.SnakeCaseName), "version", version.Info())
 	logger.Info("Build context", "build_context", version.BuildContext())
-	if user, err := user.Current(); warningRunAsRoot && err == nil && user.Uid == "0" {
-		logger.Warn(fmt.Sprintf("%s is running as root user. This exporter is designed to run as unprivileged user, root is not required.", snakeCaseName))
+	if user, err := user.Current(); opts.WarningRunAsRoot && err == nil && user.Uid == "0" {
+		logger.Warn(fmt.Sprintf("%s is running as root user. This exporter is designed to run as unprivileged user, root is not required.", opts.SnakeCaseName))
+	}
+	e := &Exporter{
+		registry:        registry,
+		snakeCaseName:   opts.SnakeCaseName,
+		namespace:       opts.Namespace,
+		checkConfig:     *checkConfig,
+		checkAtStartup:  *checkAtStartup,
+		maxProcs:        *maxProcs,
+		configFile:      *configFile,
+		shutdownTimeout: *shutdownTimeout,
+		toolkitFlags:    toolkitFlags,
+		logger:          logger,
+	}
+	var extraCollectors []prometheus.Collector
+	if e.configFile != "" {
+		e.configSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Name:      "config_last_reload_successful",
+			Help:      opts.SnakeCaseName + ": Whether the last configuration reload attempt was successful.",
+		})
+		e.configSuccessTS = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      opts.SnakeCaseName + ": Timestamp of the last successful configuration reload.",
+		})
+		extraCollectors = append(extraCollectors, e.configSuccess, e.configSuccessTS)
+		if err := e.Reload(); err != nil {
+			return nil, err
+		}
 	}
-	runtime.GOMAXPROCS(*maxProcs)
-	logger.Debug("Go MAXPROCS", "procs", runtime.GOMAXPROCS(0))
 
-	http.Handle(*metricsPath, newHandler(snakeCaseName, namespace, !*disableExporterMetrics, *maxRequests, logger))
+	if *pushURL != "" {
+		if *pushInterval <= 0 {
+			return nil, fmt.Errorf("invalid push interval: %s", *pushInterval)
+		}
+		e.pusher = newPusher(*pushURL, *pushFormat, opts.SnakeCaseName, *pushInstance, opts.Namespace, *pushInterval, logger)
+		extraCollectors = append(extraCollectors, e.pusher.failures)
+	}
+
+	mux := http.NewServeMux()
+	h, err := newHandler(registry, opts.SnakeCaseName, opts.Namespace, !*disableExporterMetrics, *maxRequests, *timeoutOffset, *enableOpenMetrics, *coalesceScrapes, logger, extraCollectors...)
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle(*metricsPath, h)
+	if e.pusher != nil {
+		e.pusher.handler = h
+	}
+	mux.Handle(*collectorsPath, newCollectorsHandler(registry, strings.TrimSpace(opts.LandingPageConfig.TitleCaseName+" Collectors"), logger))
+	if e.configFile != "" && *enableLifecycle {
+		mux.HandleFunc("/-/reload", e.serveReload)
+	}
+	if *adminTokenFile != "" {
+		token, err := os.ReadFile(*adminTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read admin token: %w", err)
+		}
+		if len(bytes.TrimSpace(token)) == 0 {
+			return nil, fmt.Errorf("admin token file %s is empty", *adminTokenFile)
+		}
+		admin := newAdminHandler(registry, h, string(bytes.TrimSpace(token)), logger)
+		mux.Handle("/-/collectors", admin)
+		mux.Handle("/-/collectors/", admin)
+	}
+	if registry.HasProbeCollectors() {
+		mux.HandleFunc(*probePath, h.serveProbe)
+	}
 	if *metricsPath != "/" {
+		landingPageConfig := opts.LandingPageConfig
 		landingConfig := web.LandingConfig{
 			HeaderColor: landingPageConfig.HeaderColor,
 			CSS:         landingPageConfig.CSS,
@@ -92,19 +274,157 @@ func Run(snakeCaseName, namespace, defaultAddress string, landingPageConfig Land
 					Address: *metricsPath,
 					Text:    "Metrics",
 				},
+				{
+					Address: *collectorsPath,
+					Text:    "Collectors",
+				},
 			}, landingPageConfig.Links...),
 		}
 		landingPage, err := web.NewLandingPage(landingConfig)
 		if err != nil {
-			logger.Error(err.Error())
-			os.Exit(1)
+			return nil, err
+		}
+		mux.Handle("/", landingPage)
+	}
+	// Handlers registered by importing net/http/pprof live on http.DefaultServeMux.
+	mux.Handle("/debug/pprof/", http.DefaultServeMux)
+
+	e.mux = mux
+	e.server = &http.Server{Handler: mux}
+	return e, nil
+}
+
+// Reload reloads the configuration file given by the --config.file flag,
+// see collector.Registry.LoadConfig.
+func (e *Exporter) Reload() error {
+	if e.configFile == "" {
+		return errors.New("no configuration file given")
+	}
+	if err := e.registry.LoadConfig(e.configFile); err != nil {
+		e.configSuccess.Set(0)
+		return fmt.Errorf("couldn't load configuration: %w", err)
+	}
+	e.configSuccess.Set(1)
+	e.configSuccessTS.SetToCurrentTime()
+	e.logger.Info("Loaded configuration file", "file", e.configFile)
+	return nil
+}
+
+// serveReload reloads the configuration file on POST requests.
+func (e *Exporter) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := e.Reload(); err != nil {
+		e.logger.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Handler returns the http.Handler which serves the metrics and the landing page.
+// Use it to embed the exporter into an existing server instead of calling Start.
+func (e *Exporter) Handler() http.Handler {
+	return e.mux
+}
+
+// Start listens on the addresses given by the --web.listen-address flag and serves the exporter.
+// If the --push.url flag is set, it also pushes the metrics every --push.interval.
+// It blocks until ctx is done or Stop is called. When ctx is done, the exporter is stopped
+// gracefully within the --web.shutdown-timeout.
+// If the --check-config flag is set, it runs Check with the report written to stdout instead, and returns its error.
+func (e *Exporter) Start(ctx context.Context) error {
+	if e.checkConfig {
+		err := e.Check(ctx, os.Stdout)
+		return errors.Join(err, e.registry.ShutdownCollectors(ctx))
+	}
+	if e.checkAtStartup {
+		e.checkStartup(ctx)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- web.ListenAndServe(e.server, e.toolkitFlags, e.logger)
+	}()
+
+	if e.pusher != nil {
+		e.pusher.start(ctx)
+	}
+
+	select {
+	case err := <-serveErr:
+		if e.pusher != nil {
+			e.pusher.stop(context.Background())
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	e.logger.Info("Shutting down", "timeout", e.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), e.shutdownTimeout)
+	defer cancel()
+	err := e.Stop(shutdownCtx)
+	if serveErr := <-serveErr; serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		err = errors.Join(err, serveErr)
+	}
+	return err
+}
+
+// Stop gracefully shuts down the server, waiting for in-flight scrapes until ctx is done,
+// stops the pushes, waiting for the current one, then shuts down all the initialized collectors.
+func (e *Exporter) Stop(ctx context.Context) error {
+	var errs []error
+	if err := e.server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("couldn't shut down the server gracefully: %w", err))
+	}
+	// The pushes gather the collectors, so they are stopped before the collectors are shut down.
+	if e.pusher != nil {
+		if err := e.pusher.stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("couldn't stop pushing: %w", err))
 		}
-		http.Handle("/", landingPage)
 	}
+	if err := e.registry.ShutdownCollectors(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Run will start the exporter, it is a wrapper of New and Exporter.Start
+// which uses kingpin.CommandLine and exits the process on failure.
+// snakeCaseName is exporter name in snake case. For example: node_exporter.
+func Run(snakeCaseName, namespace, defaultAddress string, landingPageConfig LandingPageConfig, warningRunAsRoot bool) {
+	e, err := New(Options{
+		SnakeCaseName:     snakeCaseName,
+		Namespace:         namespace,
+		DefaultAddress:    defaultAddress,
+		LandingPageConfig: landingPageConfig,
+		WarningRunAsRoot:  warningRunAsRoot,
+		Application:       kingpin.CommandLine,
+		Args:              os.Args[1:],
+	})
+	kingpin.FatalIfError(err, "")
+	runtime.GOMAXPROCS(e.maxProcs)
+	e.logger.Debug("Go MAXPROCS", "procs", runtime.GOMAXPROCS(0))
 
-	server := &http.Server{}
-	if err := web.ListenAndServe(server, toolkitFlags, logger); err != nil {
-		logger.Error(err.Error())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if e.configFile != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := e.Reload(); err != nil {
+					e.logger.Error(err.Error())
+				}
+			}
+		}()
+	}
+	if err := e.Start(ctx); err != nil {
+		e.logger.Error(err.Error())
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,328 @@
+package exporter
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+type testCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c testCollector) Update(ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func newTestRegistry() *collector.Registry {
+	registry := collector.NewRegistry()
+	registry.RegisterCollector("test", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return testCollector{
+			desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "test", "up"), "Test metric.", nil, nil),
+		}, nil
+	}, collector.WithDescription("Collects test metrics."))
+	return registry
+}
+
+func TestExporterHandler(t *testing.T) {
+	for _, namespace := range []string{"first", "second"} {
+		t.Run(namespace, func(t *testing.T) {
+			t.Parallel()
+			e, err := New(Options{
+				SnakeCaseName: "test_exporter",
+				Namespace:     namespace,
+				Registry:      newTestRegistry(),
+				Args:          []string{"--web.telemetry-path=/test-metrics", "--log.level=error"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/test-metrics", nil))
+			body, _ := io.ReadAll(rec.Body)
+			for _, want := range []string{
+				namespace + "_test_up 1",
+				namespace + `_scrape_collector_success{collector="test",reason=""} 1`,
+			} {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("response doesn't contain %q:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestNewInvalidFlag(t *testing.T) {
+	if _, err := New(Options{SnakeCaseName: "test_exporter", Registry: newTestRegistry(), Args: []string{"--no-such-flag"}}); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
+
+func TestNewFactoryError(t *testing.T) {
+	registry := newTestRegistry()
+	registry.RegisterCollector("broken", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return nil, errors.New("broken factory")
+	})
+	_, err := New(Options{SnakeCaseName: "test_exporter", Registry: registry, Args: []string{"--log.level=error"}})
+	if err == nil || !strings.Contains(err.Error(), "broken factory") {
+		t.Errorf("got error %v, want the error of the factory", err)
+	}
+}
+
+func TestNewKeepsGOMAXPROCS(t *testing.T) {
+	before := runtime.GOMAXPROCS(0)
+	if _, err := New(Options{SnakeCaseName: "test_exporter", Registry: newTestRegistry(), Args: []string{"--runtime.gomaxprocs=" + strconv.Itoa(before+1), "--log.level=error"}}); err != nil {
+		t.Fatal(err)
+	}
+	if after := runtime.GOMAXPROCS(0); after != before {
+		runtime.GOMAXPROCS(before)
+		t.Errorf("New changed GOMAXPROCS from %d to %d", before, after)
+	}
+}
+
+type probeCollector struct {
+	desc   *prometheus.Desc
+	target string
+}
+
+func (c probeCollector) Update(ch chan<- prometheus.Metric) error {
+	if c.target == "unreachable" {
+		return collector.ErrUpstreamUnavailable
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, c.target)
+	return nil
+}
+
+func TestExporterProbe(t *testing.T) {
+	t.Parallel()
+	registry := newTestRegistry()
+	registry.RegisterProbeCollector("probe", collector.DefaultEnabled, func(target, module, namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return probeCollector{
+			desc:   prometheus.NewDesc(prometheus.BuildFQName(namespace, module, "up"), "Test probe metric.", []string{"target"}, nil),
+			target: target,
+		}, nil
+	}, collector.WithCircuitBreaker(1, time.Hour), collector.WithPanicQuarantine(1))
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      registry,
+		Args:          []string{"--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		query   string
+		code    int
+		want    []string
+		notWant []string
+	}{
+		{"", 400, []string{"Target parameter is missing"}, nil},
+		{"?target=localhost:1234&module=mod", 200, []string{`test_mod_up{target="localhost:1234"} 1`, "probe_success 1", "probe_duration_seconds"}, nil},
+		{"?target=unreachable&module=mod", 200, []string{"probe_success 0"}, nil},
+		// The failure of another target neither opens the circuit breaker nor shows up in the error metrics.
+		{"?target=other&module=mod", 200, []string{`test_mod_up{target="other"} 1`, "probe_success 1"}, []string{`reason="upstream_unavailable"`}},
+	} {
+		rec := httptest.NewRecorder()
+		e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/probe"+tc.query, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%q: got status %d, want %d", tc.query, rec.Code, tc.code)
+		}
+		body, _ := io.ReadAll(rec.Body)
+		for _, want := range tc.want {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("%q: response doesn't contain %q:\n%s", tc.query, want, body)
+			}
+		}
+		for _, notWant := range tc.notWant {
+			if strings.Contains(string(body), notWant) {
+				t.Errorf("%q: response contains %q:\n%s", tc.query, notWant, body)
+			}
+		}
+		if strings.Contains(string(body), "test_test_up") {
+			t.Errorf("%q: response contains the metrics of regular collectors:\n%s", tc.query, body)
+		}
+	}
+}
+
+func TestExporterReload(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(filename, []byte("collectors: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      newTestRegistry(),
+		Args:          []string{"--config.file=" + filename, "--web.enable-lifecycle", "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutLifecycle, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      newTestRegistry(),
+		Args:          []string{"--config.file=" + filename, "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	withoutLifecycle.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+	if rec.Code == 200 {
+		t.Error("/-/reload is served without --web.enable-lifecycle")
+	}
+
+	for _, tc := range []struct {
+		content string
+		code    int
+		want    string
+	}{
+		{"collectors: {}\n", 200, "test_config_last_reload_successful 1"},
+		{"collectors:\n  test: {}\n", 500, "test_config_last_reload_successful 0"},
+	} {
+		if err := os.WriteFile(filename, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		e.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+		if rec.Code != tc.code {
+			t.Errorf("%q: got status %d, want %d", tc.content, rec.Code, tc.code)
+		}
+
+		rec = httptest.NewRecorder()
+		e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body, _ := io.ReadAll(rec.Body)
+		if !strings.Contains(string(body), tc.want) {
+			t.Errorf("%q: response doesn't contain %q:\n%s", tc.content, tc.want, body)
+		}
+	}
+}
+
+type slowCollector struct {
+	desc  *prometheus.Desc
+	calls *atomic.Int32
+}
+
+func (c slowCollector) Update(ch chan<- prometheus.Metric) error {
+	c.calls.Add(1)
+	time.Sleep(200 * time.Millisecond)
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestExporterCoalesceScrapes(t *testing.T) {
+	var calls atomic.Int32
+	registry := collector.NewRegistry()
+	registry.RegisterCollector("slow", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return slowCollector{
+			desc:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "slow", "up"), "Slow metric.", nil, nil),
+			calls: &calls,
+		}, nil
+	})
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      registry,
+		Args:          []string{"--web.coalesce-scrapes", "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 2)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			body, _ := io.ReadAll(rec.Body)
+			bodies[i] = string(body)
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("collector was updated %d times by concurrent scrapes, want 1", n)
+	}
+	for i, body := range bodies {
+		if !strings.Contains(body, "test_slow_up 1") {
+			t.Errorf("response %d doesn't contain the collector's metric:\n%s", i, body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if n := calls.Load(); n != 2 {
+		t.Errorf("collector was updated %d times after a later scrape, want 2 as results are not cached", n)
+	}
+}
+
+func TestExporterScrapeTimeout(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		header string
+		want   string
+	}{
+		{"no timeout", nil, "", `reason=""} 1`},
+		{"header", []string{"--web.timeout-offset=0s"}, "0.05", `reason="timeout"} 0`},
+		{"header minus offset", []string{"--web.timeout-offset=450ms"}, "0.5", `reason="timeout"} 0`},
+		{"header longer than update", []string{"--web.timeout-offset=0s"}, "5", `reason=""} 1`},
+		{"collector timeout", []string{"--collector.slow.timeout=50ms"}, "", `reason="timeout"} 0`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var calls atomic.Int32
+			registry := collector.NewRegistry()
+			registry.RegisterCollector("slow", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+				return slowCollector{
+					desc:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "slow", "up"), "Slow metric.", nil, nil),
+					calls: &calls,
+				}, nil
+			})
+			e, err := New(Options{
+				SnakeCaseName: "test_exporter",
+				Namespace:     "test",
+				Registry:      registry,
+				Args:          append(tc.args, "--log.level=error"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tc.header != "" {
+				req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			e.Handler().ServeHTTP(rec, req)
+			body, _ := io.ReadAll(rec.Body)
+			if want := `test_scrape_collector_success{collector="slow",` + tc.want; !strings.Contains(string(body), want) {
+				t.Errorf("response doesn't contain %q:\n%s", want, body)
+			}
+		})
+	}
+}
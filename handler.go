@@ -1,16 +1,23 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	promcollectors "github.com/prometheus/client_golang/prometheus/collectors"
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/rea1shane/exporter/collector"
 )
@@ -19,37 +26,64 @@ import (
 // created on the fly, if filtering is requested. Create instances with
 // newHandler.
 type handler struct {
+	registry                *collector.Registry
 	snakeCaseName           string
 	namespace               string
+	mtx                     sync.RWMutex // mtx protects unfilteredHandler, unfilteredCollection and enabledCollectors, which are rebuilt when a collector is enabled or disabled at runtime
 	unfilteredHandler       http.Handler
-	enabledCollectors       []string             // enabledCollectors list is used for logging and filtering
-	exporterMetricsRegistry *prometheus.Registry // exporterMetricsRegistry is a separate registry for the metrics about the exporter itself.
+	unfilteredCollection    *collector.Collection // unfilteredCollection is the collection of unfilteredHandler, it is gathered by unfilteredGatherer
+	enabledCollectors       []string              // enabledCollectors list is used for logging and filtering
+	exporterMetricsRegistry *prometheus.Registry  // exporterMetricsRegistry is a separate registry for the metrics about the exporter itself.
 	includeExporterMetrics  bool
 	maxRequests             int
+	timeoutOffset           time.Duration          // timeoutOffset is subtracted from the scrape timeout sent by Prometheus
+	enableOpenMetrics       bool                   // enableOpenMetrics enables the OpenMetrics exposition format, which is required for exemplars
+	coalesceScrapes         bool                   // coalesceScrapes makes concurrent scrapes with the same filters share a single collection, see gatherer
+	scrapes                 singleflight.Group     // scrapes coalesces the concurrent scrapes by filters if coalesceScrapes is true
+	extraCollectors         []prometheus.Collector // extraCollectors are registered to the registry of every scrape, e.g. the metrics about the configuration file
+	inFlightSem             chan struct{}          // inFlightSem limits the number of parallel scrape requests, nil if unlimited
 	logger                  *slog.Logger
 }
 
-func newHandler(snakeCaseName, namespace string, includeExporterMetrics bool, maxRequests int, logger *slog.Logger) *handler {
+func newHandler(registry *collector.Registry, snakeCaseName, namespace string, includeExporterMetrics bool, maxRequests int, timeoutOffset time.Duration, enableOpenMetrics, coalesceScrapes bool, logger *slog.Logger, extraCollectors ...prometheus.Collector) (*handler, error) {
 	h := &handler{
+		registry:                registry,
 		snakeCaseName:           snakeCaseName,
 		namespace:               namespace,
 		exporterMetricsRegistry: prometheus.NewRegistry(),
 		includeExporterMetrics:  includeExporterMetrics,
 		maxRequests:             maxRequests,
+		timeoutOffset:           timeoutOffset,
+		enableOpenMetrics:       enableOpenMetrics,
+		coalesceScrapes:         coalesceScrapes,
+		extraCollectors:         extraCollectors,
 		logger:                  logger,
 	}
+	if maxRequests > 0 {
+		h.inFlightSem = make(chan struct{}, maxRequests)
+	}
 	if h.includeExporterMetrics {
 		h.exporterMetricsRegistry.MustRegister(
 			promcollectors.NewProcessCollector(promcollectors.ProcessCollectorOpts{}),
 			promcollectors.NewGoCollector(),
 		)
 	}
-	if innerHandler, err := h.innerHandler(); err != nil {
-		panic(fmt.Sprintf("Couldn't create metrics handler: %s", err))
-	} else {
-		h.unfilteredHandler = innerHandler
+	if err := h.rebuild(); err != nil {
+		return nil, fmt.Errorf("couldn't create metrics handler: %w", err)
+	}
+	return h, nil
+}
+
+// rebuild creates the unfiltered handler from the currently enabled collectors.
+func (h *handler) rebuild() error {
+	innerHandler, err := h.innerHandler()
+	if err != nil {
+		return err
 	}
-	return h
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.unfilteredHandler = innerHandler
+	return nil
 }
 
 // ServeHTTP implements http.Handler.
@@ -60,9 +94,13 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	excludes := r.URL.Query()["exclude[]"]
 	h.logger.Debug("exclude query:", "excludes", excludes)
 
+	h.mtx.RLock()
+	unfilteredHandler, enabledCollectors := h.unfilteredHandler, h.enabledCollectors
+	h.mtx.RUnlock()
+
 	if len(collects) == 0 && len(excludes) == 0 {
 		// No filters, use the prepared unfiltered handler.
-		h.unfilteredHandler.ServeHTTP(w, r)
+		unfilteredHandler.ServeHTTP(w, r)
 		return
 	}
 
@@ -77,7 +115,7 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if len(excludes) > 0 {
 		// In exclude mode, filtered collectors = enabled - excludeed.
 		f := []string{}
-		for _, c := range h.enabledCollectors {
+		for _, c := range enabledCollectors {
 			if (slices.Index(excludes, c)) == -1 {
 				f = append(f, c)
 			}
@@ -102,56 +140,252 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // (in which case it will log all the collectors enabled via command-line
 // flags).
 func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
-	collection, err := collector.NewCollection(h.snakeCaseName, h.namespace, h.logger, filters...)
+	collection, err := collector.NewCollection(h.registry, h.snakeCaseName, h.namespace, h.logger, filters...)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create collector: %s", err)
 	}
 
+	// Registering the collection once here reports conflicting descriptors
+	// at creation time rather than on every scrape.
+	if _, err := h.newRegistry(collection); err != nil {
+		return nil, err
+	}
+
 	// Only log the creation of an unfiltered handler, which should happen
-	// only once upon startup.
+	// only once upon startup. The enabled collectors are only replaced once
+	// the collection is known to be valid.
 	if len(filters) == 0 {
 		h.logger.Info("Enabled collectors")
+		var enabledCollectors []string
 		for n := range collection.Collectors {
-			h.enabledCollectors = append(h.enabledCollectors, n)
+			enabledCollectors = append(enabledCollectors, n)
 		}
-		sort.Strings(h.enabledCollectors)
-		for _, c := range h.enabledCollectors {
+		sort.Strings(enabledCollectors)
+		for _, c := range enabledCollectors {
 			h.logger.Info(c)
 		}
+		h.mtx.Lock()
+		h.enabledCollectors = enabledCollectors
+		h.unfilteredCollection = collection
+		h.mtx.Unlock()
 	}
 
-	r := prometheus.NewRegistry()
-	r.MustRegister(versioncollector.NewCollector(h.snakeCaseName))
-	if err := r.Register(collection); err != nil {
-		return nil, fmt.Errorf("couldn't register %s collector: %s", h.namespace, err)
-	}
+	key := strings.Join(slices.Sorted(slices.Values(filters)), ",")
+	// The registry is built for every request, so that the collection can be
+	// bound to the request's context, which is cancelled when the client
+	// disconnects.
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		release, ok := h.acquireInFlight(w)
+		if !ok {
+			return
+		}
+		defer release()
+
+		ctx, cancel := h.scrapeContext(req)
+		defer cancel()
 
-	var handler http.Handler
+		r, err := h.gatherer(ctx, key, collection)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if h.includeExporterMetrics {
+			promhttp.HandlerFor(
+				prometheus.Gatherers{h.exporterMetricsRegistry, r},
+				h.handlerOpts(h.exporterMetricsRegistry),
+			).ServeHTTP(w, req)
+		} else {
+			promhttp.HandlerFor(
+				r,
+				h.handlerOpts(nil),
+			).ServeHTTP(w, req)
+		}
+	})
 	if h.includeExporterMetrics {
-		handler = promhttp.HandlerFor(
-			prometheus.Gatherers{h.exporterMetricsRegistry, r},
-			promhttp.HandlerOpts{
-				ErrorLog:            slog.NewLogLogger(h.logger.Handler(), slog.LevelError),
-				ErrorHandling:       promhttp.ContinueOnError,
-				MaxRequestsInFlight: h.maxRequests,
-				Registry:            h.exporterMetricsRegistry,
-			},
-		)
 		// Note that we have to use h.exporterMetricsRegistry here to
 		// use the same promhttp metrics for all expositions.
 		handler = promhttp.InstrumentMetricHandler(
 			h.exporterMetricsRegistry, handler,
 		)
-	} else {
-		handler = promhttp.HandlerFor(
-			r,
-			promhttp.HandlerOpts{
-				ErrorLog:            slog.NewLogLogger(h.logger.Handler(), slog.LevelError),
-				ErrorHandling:       promhttp.ContinueOnError,
-				MaxRequestsInFlight: h.maxRequests,
-			},
-		)
 	}
 
 	return handler, nil
 }
+
+// unfilteredGatherer returns the gatherer of the metrics served by the unfiltered handler for ctx,
+// including the metrics about the exporter itself if they are enabled.
+func (h *handler) unfilteredGatherer(ctx context.Context) (prometheus.Gatherer, error) {
+	h.mtx.RLock()
+	collection := h.unfilteredCollection
+	h.mtx.RUnlock()
+	g, err := h.gatherer(ctx, "", collection)
+	if err != nil {
+		return nil, err
+	}
+	if h.includeExporterMetrics {
+		return prometheus.Gatherers{h.exporterMetricsRegistry, g}, nil
+	}
+	return g, nil
+}
+
+// gatherer returns the gatherer of the metrics of collection for a scrape with ctx.
+// If h.coalesceScrapes is true, the concurrent scrapes with the same key (the filters of collection) share
+// a single gathering: the first scrape collects, the others wait for its result. The gathering is not
+// cancelled when the first scrape's client disconnects, but it keeps the first scrape's deadline.
+// Every scrape still stops waiting at its own deadline. No result outlives the gathering, so nothing stale is served.
+func (h *handler) gatherer(ctx context.Context, key string, collection *collector.Collection) (prometheus.Gatherer, error) {
+	if !h.coalesceScrapes {
+		return h.newRegistry(collection.WithContext(ctx))
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		resultCh := h.scrapes.DoChan(key, func() (any, error) {
+			gatherCtx := context.WithoutCancel(ctx)
+			if deadline, ok := ctx.Deadline(); ok {
+				var cancel context.CancelFunc
+				gatherCtx, cancel = context.WithDeadline(gatherCtx, deadline)
+				defer cancel()
+			}
+			r, err := h.newRegistry(collection.WithContext(gatherCtx))
+			if err != nil {
+				return nil, err
+			}
+			mfs, err := r.Gather()
+			// Gather returns the metrics which could be gathered along with the error.
+			return gatherResult{mfs: mfs, err: err}, nil
+		})
+		select {
+		case result := <-resultCh:
+			if result.Err != nil {
+				return nil, result.Err
+			}
+			if result.Shared {
+				h.logger.Debug("Coalesced concurrent scrapes", "collectors", key)
+			}
+			gathered := result.Val.(gatherResult)
+			return gathered.mfs, gathered.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}), nil
+}
+
+// gatherResult is the result of a gathering shared by coalesced scrapes.
+type gatherResult struct {
+	mfs []*dto.MetricFamily
+	err error
+}
+
+// newRegistry creates a registry which contains the version collector, h.extraCollectors and the given collection.
+func (h *handler) newRegistry(collection *collector.Collection) (*prometheus.Registry, error) {
+	r := prometheus.NewRegistry()
+	r.MustRegister(versioncollector.NewCollector(h.snakeCaseName))
+	r.MustRegister(h.extraCollectors...)
+	if err := r.Register(collection); err != nil {
+		return nil, fmt.Errorf("couldn't register %s collector: %s", h.namespace, err)
+	}
+	return r, nil
+}
+
+// serveProbe serves the metrics of the probe collectors for the target and
+// module parameters of the request, in the fashion of blackbox_exporter's
+// /probe endpoint.
+func (h *handler) serveProbe(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	module := params.Get("module")
+
+	release, ok := h.acquireInFlight(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, cancel := h.scrapeContext(req)
+	defer cancel()
+
+	collection, err := collector.NewProbeCollection(h.registry, h.snakeCaseName, h.namespace, target, module, h.logger)
+	if err != nil {
+		h.logger.Warn("Couldn't create probe collectors:", "target", target, "module", module, "err", err)
+		http.Error(w, fmt.Sprintf("Couldn't create probe collectors: %s", err), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		if err := collector.ShutdownCollection(context.Background(), collection); err != nil {
+			h.logger.Warn("Couldn't shut down probe collectors:", "target", target, "module", module, "err", err)
+		}
+	}()
+
+	r := prometheus.NewRegistry()
+	if err := r.Register(collection.WithContext(ctx)); err != nil {
+		http.Error(w, fmt.Sprintf("couldn't register %s probe collector: %s", h.namespace, err), http.StatusInternalServerError)
+		return
+	}
+	promhttp.HandlerFor(
+		r,
+		h.handlerOpts(nil),
+	).ServeHTTP(w, req)
+}
+
+// handlerOpts returns the options of the promhttp handlers.
+// registry is used to register the promhttp error metrics, it can be nil.
+func (h *handler) handlerOpts(registry prometheus.Registerer) promhttp.HandlerOpts {
+	return promhttp.HandlerOpts{
+		ErrorLog:                            slog.NewLogLogger(h.logger.Handler(), slog.LevelError),
+		ErrorHandling:                       promhttp.ContinueOnError,
+		Registry:                            registry,
+		EnableOpenMetrics:                   h.enableOpenMetrics,
+		EnableOpenMetricsTextCreatedSamples: h.enableOpenMetrics,
+	}
+}
+
+// acquireInFlight takes one of the h.maxRequests parallel scrape requests.
+// If the limit is reached, it responds with 503 and returns false.
+// Otherwise the returned release function has to be called once the request is served.
+func (h *handler) acquireInFlight(w http.ResponseWriter) (release func(), ok bool) {
+	if h.inFlightSem == nil {
+		return func() {}, true
+	}
+	select {
+	case h.inFlightSem <- struct{}{}:
+		return func() { <-h.inFlightSem }, true
+	default:
+		http.Error(w, fmt.Sprintf(
+			"Limit of concurrent requests reached (%d), try again later.", h.maxRequests,
+		), http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
+
+// scrapeContext returns the context of the request, with the scrape timeout
+// as deadline if Prometheus sent one.
+func (h *handler) scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if timeout, ok := h.scrapeTimeout(r); ok {
+		return context.WithTimeout(r.Context(), timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// scrapeTimeout returns the timeout of the scrape announced by Prometheus
+// in the X-Prometheus-Scrape-Timeout-Seconds header minus h.timeoutOffset.
+// The offset leaves time to send the response before Prometheus gives up.
+func (h *handler) scrapeTimeout(r *http.Request) (time.Duration, bool) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		h.logger.Warn("Couldn't parse scrape timeout header:", "value", v, "err", err)
+		return 0, false
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > h.timeoutOffset {
+		timeout -= h.timeoutOffset
+	}
+	return timeout, true
+}
@@ -1,6 +1,9 @@
 package metric
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/rea1shane/exporter/util"
@@ -12,12 +15,279 @@ type TypedDesc struct {
 }
 
 // PushMetric helps construct and convert a variety of value types into Prometheus float64 metrics.
+// If the metric can't be created, e.g. the value can't be converted or the number of label values is wrong,
+// an invalid metric carrying the error is pushed instead, see PushError.
 func (d *TypedDesc) PushMetric(ch chan<- prometheus.Metric, value any, labelValues ...string) {
+	m, err := d.newMetric(value, labelValues...)
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+	ch <- m
+}
+
+// TryPushMetric is like PushMetric, but returns the error instead of pushing an invalid metric.
+func (d *TypedDesc) TryPushMetric(ch chan<- prometheus.Metric, value any, labelValues ...string) error {
+	m, err := d.newMetric(value, labelValues...)
+	if err != nil {
+		return err
+	}
+	ch <- m
+	return nil
+}
+
+// newMetric creates the metric pushed by PushMetric and TryPushMetric.
+func (d *TypedDesc) newMetric(value any, labelValues ...string) (prometheus.Metric, error) {
 	fVal, err := util.AnyToFloat64(value)
 	if err != nil {
-		// TODO handler error
+		return nil, fmt.Errorf("%s: %w", d.Desc, err)
+	}
+	return prometheus.NewConstMetric(d.Desc, d.ValueType, fVal, labelValues...)
+}
+
+// PushMetricWithCreatedTimestamp is like PushMetric, but also exposes created as the time the counter was created (the _created sample in OpenMetrics).
+func (d *TypedDesc) PushMetricWithCreatedTimestamp(ch chan<- prometheus.Metric, value any, created time.Time, labelValues ...string) {
+	fVal, err := util.AnyToFloat64(value)
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+
+	m, err := prometheus.NewConstMetricWithCreatedTimestamp(d.Desc, d.ValueType, fVal, created, labelValues...)
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+	ch <- m
+}
+
+// PushMetricWithExemplars is like PushMetric, but attaches exemplars to the counter, e.g. to link it to a trace.
+// If created is not zero, it is exposed like in PushMetricWithCreatedTimestamp.
+// Exemplars are only exposed in the OpenMetrics format.
+func (d *TypedDesc) PushMetricWithExemplars(ch chan<- prometheus.Metric, value any, created time.Time, exemplars []prometheus.Exemplar, labelValues ...string) {
+	fVal, err := util.AnyToFloat64(value)
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+
+	var m prometheus.Metric
+	if created.IsZero() {
+		m, err = prometheus.NewConstMetric(d.Desc, d.ValueType, fVal, labelValues...)
+	} else {
+		m, err = prometheus.NewConstMetricWithCreatedTimestamp(d.Desc, d.ValueType, fVal, created, labelValues...)
+	}
+	if err == nil && len(exemplars) > 0 {
+		m, err = prometheus.NewMetricWithExemplars(m, exemplars...)
+	}
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+	ch <- m
+}
+
+// PushHistogramWithExemplars pushes a histogram with exemplars attached to its buckets, e.g. to link latencies to traces.
+// buckets maps the upper bounds to the cumulative counts, the +Inf bucket is implied by count.
+// Each exemplar is attached to the bucket its value falls into. If created is not zero, it is exposed as the time
+// the histogram was created. The ValueType of d is ignored.
+func (d *TypedDesc) PushHistogramWithExemplars(ch chan<- prometheus.Metric, count uint64, sum float64, buckets map[float64]uint64, created time.Time, exemplars []prometheus.Exemplar, labelValues ...string) {
+	var (
+		m   prometheus.Metric
+		err error
+	)
+	if created.IsZero() {
+		m, err = prometheus.NewConstHistogram(d.Desc, count, sum, buckets, labelValues...)
+	} else {
+		m, err = prometheus.NewConstHistogramWithCreatedTimestamp(d.Desc, count, sum, buckets, created, labelValues...)
+	}
+	if err == nil && len(exemplars) > 0 {
+		m, err = prometheus.NewMetricWithExemplars(m, exemplars...)
+	}
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+	ch <- m
+}
+
+// PushHistogram helps construct a histogram from a variety of value types, like PushMetric.
+// count and sum are converted with util.AnyToUint64 and util.AnyToFloat64, buckets is any map which keys are the
+// upper bounds and values are the cumulative counts, e.g. map[string]int{"0.1": 3, "1": 5}. The +Inf bucket is
+// implied by count. The ValueType of d is ignored.
+// If the histogram can't be created, an invalid metric carrying the error is pushed instead, see PushError.
+func (d *TypedDesc) PushHistogram(ch chan<- prometheus.Metric, count, sum, buckets any, labelValues ...string) {
+	m, err := d.newHistogram(count, sum, buckets, labelValues...)
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+	ch <- m
+}
+
+// TryPushHistogram is like PushHistogram, but returns the error instead of pushing an invalid metric.
+func (d *TypedDesc) TryPushHistogram(ch chan<- prometheus.Metric, count, sum, buckets any, labelValues ...string) error {
+	m, err := d.newHistogram(count, sum, buckets, labelValues...)
+	if err != nil {
+		return err
+	}
+	ch <- m
+	return nil
+}
+
+// newHistogram creates the histogram pushed by PushHistogram and TryPushHistogram.
+func (d *TypedDesc) newHistogram(count, sum, buckets any, labelValues ...string) (prometheus.Metric, error) {
+	uCount, err := util.AnyToUint64(count)
+	if err != nil {
+		return nil, fmt.Errorf("%s: count: %w", d.Desc, err)
+	}
+	fSum, err := util.AnyToFloat64(sum)
+	if err != nil {
+		return nil, fmt.Errorf("%s: sum: %w", d.Desc, err)
+	}
+	mBuckets, err := util.AnyToMap(buckets, util.AnyToFloat64, util.AnyToUint64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: buckets: %w", d.Desc, err)
+	}
+	return prometheus.NewConstHistogram(d.Desc, uCount, fSum, mBuckets, labelValues...)
+}
+
+// PushSummary helps construct a summary from a variety of value types, like PushMetric.
+// count and sum are converted like in PushHistogram, quantiles is any map which keys are the quantiles and values
+// are the observed values, e.g. map[string]float32{"0.5": 0.2, "0.99": 1.3}. The ValueType of d is ignored.
+// If the summary can't be created, an invalid metric carrying the error is pushed instead, see PushError.
+func (d *TypedDesc) PushSummary(ch chan<- prometheus.Metric, count, sum, quantiles any, labelValues ...string) {
+	m, err := d.newSummary(count, sum, quantiles, labelValues...)
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
 		return
 	}
+	ch <- m
+}
+
+// TryPushSummary is like PushSummary, but returns the error instead of pushing an invalid metric.
+func (d *TypedDesc) TryPushSummary(ch chan<- prometheus.Metric, count, sum, quantiles any, labelValues ...string) error {
+	m, err := d.newSummary(count, sum, quantiles, labelValues...)
+	if err != nil {
+		return err
+	}
+	ch <- m
+	return nil
+}
+
+// newSummary creates the summary pushed by PushSummary and TryPushSummary.
+func (d *TypedDesc) newSummary(count, sum, quantiles any, labelValues ...string) (prometheus.Metric, error) {
+	uCount, err := util.AnyToUint64(count)
+	if err != nil {
+		return nil, fmt.Errorf("%s: count: %w", d.Desc, err)
+	}
+	fSum, err := util.AnyToFloat64(sum)
+	if err != nil {
+		return nil, fmt.Errorf("%s: sum: %w", d.Desc, err)
+	}
+	mQuantiles, err := util.AnyToMap(quantiles, util.AnyToFloat64, util.AnyToFloat64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: quantiles: %w", d.Desc, err)
+	}
+	return prometheus.NewConstSummary(d.Desc, uCount, fSum, mQuantiles, labelValues...)
+}
+
+// NativeHistogram is a native histogram pushed by PushNativeHistogram.
+// The fields typed any accept a variety of value types, like the arguments of PushHistogram.
+type NativeHistogram struct {
+	Count           any       // Count is the number of observations.
+	Sum             any       // Sum is the sum of the observations.
+	Schema          int32     // Schema defines the bucket boundaries, between -4 and 8, see prometheus.HistogramOpts.NativeHistogramBucketFactor.
+	ZeroThreshold   float64   // ZeroThreshold is the width of the zero bucket.
+	ZeroCount       any       // ZeroCount is the number of observations in the zero bucket.
+	PositiveBuckets any       // PositiveBuckets maps the bucket indexes to the non-cumulative counts of the positive observations.
+	NegativeBuckets any       // NegativeBuckets maps the bucket indexes to the non-cumulative counts of the negative observations.
+	Created         time.Time // Created is the time the histogram was created, it is always exposed and should not be zero.
+}
+
+// PushNativeHistogram pushes a native histogram. Native histograms are only exposed in the protobuf format,
+// the text formats fall back to the histogram's count and sum. The ValueType of d is ignored.
+// If the histogram can't be created, an invalid metric carrying the error is pushed instead, see PushError.
+func (d *TypedDesc) PushNativeHistogram(ch chan<- prometheus.Metric, h NativeHistogram, labelValues ...string) {
+	m, err := d.newNativeHistogram(h, labelValues...)
+	if err != nil {
+		pushInvalid(ch, d.Desc, err)
+		return
+	}
+	ch <- m
+}
 
-	ch <- prometheus.MustNewConstMetric(d.Desc, d.ValueType, fVal, labelValues...)
+// TryPushNativeHistogram is like PushNativeHistogram, but returns the error instead of pushing an invalid metric.
+func (d *TypedDesc) TryPushNativeHistogram(ch chan<- prometheus.Metric, h NativeHistogram, labelValues ...string) error {
+	m, err := d.newNativeHistogram(h, labelValues...)
+	if err != nil {
+		return err
+	}
+	ch <- m
+	return nil
+}
+
+// newNativeHistogram creates the histogram pushed by PushNativeHistogram and TryPushNativeHistogram.
+func (d *TypedDesc) newNativeHistogram(h NativeHistogram, labelValues ...string) (prometheus.Metric, error) {
+	uCount, err := util.AnyToUint64(h.Count)
+	if err != nil {
+		return nil, fmt.Errorf("%s: count: %w", d.Desc, err)
+	}
+	fSum, err := util.AnyToFloat64(h.Sum)
+	if err != nil {
+		return nil, fmt.Errorf("%s: sum: %w", d.Desc, err)
+	}
+	var uZeroCount uint64
+	if h.ZeroCount != nil {
+		if uZeroCount, err = util.AnyToUint64(h.ZeroCount); err != nil {
+			return nil, fmt.Errorf("%s: zero count: %w", d.Desc, err)
+		}
+	}
+	positiveBuckets, err := nativeBuckets(h.PositiveBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("%s: positive buckets: %w", d.Desc, err)
+	}
+	negativeBuckets, err := nativeBuckets(h.NegativeBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("%s: negative buckets: %w", d.Desc, err)
+	}
+	return prometheus.NewConstNativeHistogram(d.Desc, uCount, fSum, positiveBuckets, negativeBuckets, uZeroCount, h.Schema, h.ZeroThreshold, h.Created, labelValues...)
+}
+
+// nativeBuckets converts the buckets of a NativeHistogram, nil means no buckets.
+func nativeBuckets(buckets any) (map[int]int64, error) {
+	if buckets == nil {
+		return map[int]int64{}, nil
+	}
+	return util.AnyToMap(buckets, util.AnyToInt, util.AnyToInt64)
+}
+
+// invalidMetric is pushed by the methods of TypedDesc instead of a metric which couldn't be created.
+type invalidMetric struct {
+	prometheus.Metric
+	err error
+}
+
+// pushInvalid pushes an invalid metric carrying err, see PushError.
+func pushInvalid(ch chan<- prometheus.Metric, desc *prometheus.Desc, err error) {
+	ch <- NewInvalidMetric(desc, err)
+}
+
+// NewInvalidMetric is like prometheus.NewInvalidMetric, but the metric is recognized by PushError.
+// Push it instead of a metric which couldn't be created without using TypedDesc.
+func NewInvalidMetric(desc *prometheus.Desc, err error) prometheus.Metric {
+	return invalidMetric{
+		Metric: prometheus.NewInvalidMetric(desc, err),
+		err:    err,
+	}
+}
+
+// PushError returns the error carried by m if it has been pushed by a method of TypedDesc instead of a metric
+// which couldn't be created, otherwise nil. The collections of the collector package drop these metrics and count
+// them in the collector_push_errors_total metric. Elsewhere they fail the gathering like prometheus.NewInvalidMetric.
+func PushError(m prometheus.Metric) error {
+	if invalid, ok := m.(invalidMetric); ok {
+		return invalid.err
+	}
+	return nil
 }
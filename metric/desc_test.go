@@ -0,0 +1,190 @@
+package metric
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+type pushCollector func(ch chan<- prometheus.Metric)
+
+func (f pushCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (f pushCollector) Collect(ch chan<- prometheus.Metric) { f(ch) }
+
+// exposeOpenMetrics returns the OpenMetrics exposition of the metrics pushed by push.
+func exposeOpenMetrics(t *testing.T, push func(ch chan<- prometheus.Metric)) string {
+	t.Helper()
+	r := prometheus.NewRegistry()
+	r.MustRegister(pushCollector(push))
+	handler := promhttp.HandlerFor(r, promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	})
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeOpenMetrics)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestPushWithExemplarsAndCreatedTimestamp(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	exemplarTime := time.Unix(1700000100, 0)
+	requests := TypedDesc{
+		Desc:      prometheus.NewDesc("requests_total", "Requests.", []string{"path"}, nil),
+		ValueType: prometheus.CounterValue,
+	}
+	latency := TypedDesc{
+		Desc: prometheus.NewDesc("latency_seconds", "Latency.", nil, nil),
+	}
+
+	body := exposeOpenMetrics(t, func(ch chan<- prometheus.Metric) {
+		requests.PushMetricWithExemplars(ch, 42, created, []prometheus.Exemplar{
+			{Value: 1, Labels: prometheus.Labels{"trace_id": "abc"}, Timestamp: exemplarTime},
+		}, "/")
+		requests.PushMetricWithCreatedTimestamp(ch, "7", created, "/health")
+		latency.PushHistogramWithExemplars(ch, 3, 0.6, map[float64]uint64{0.1: 1, 0.5: 2}, created, []prometheus.Exemplar{
+			{Value: 0.3, Labels: prometheus.Labels{"trace_id": "def"}, Timestamp: exemplarTime},
+		})
+	})
+
+	for _, want := range []string{
+		`requests_total{path="/"} 42.0 # {trace_id="abc"} 1.0 1.7000001e+09`,
+		`requests_created{path="/"} 1.7e+09`,
+		`requests_total{path="/health"} 7.0`,
+		`requests_created{path="/health"} 1.7e+09`,
+		`latency_seconds_bucket{le="0.5"} 2 # {trace_id="def"} 0.3 1.7000001e+09`,
+		`latency_seconds_created 1.7e+09`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestPushHistogramAndSummary(t *testing.T) {
+	latency := TypedDesc{
+		Desc: prometheus.NewDesc("latency_seconds", "Latency.", []string{"path"}, nil),
+	}
+	size := TypedDesc{
+		Desc: prometheus.NewDesc("size_bytes", "Size.", nil, nil),
+	}
+	native := TypedDesc{
+		Desc: prometheus.NewDesc("native_seconds", "Native.", nil, nil),
+	}
+
+	var metrics []prometheus.Metric
+	ch := make(chan prometheus.Metric, 10)
+	latency.PushHistogram(ch, "5", 1.5, map[string]int{"0.1": 3, "1": 4}, "/")
+	latency.PushHistogram(ch, 5, 1.5, map[string]int{"invalid": 3}, "/")
+	size.PushSummary(ch, int32(2), float32(300), map[float64]string{0.5: "100", 0.99: "200"})
+	size.PushSummary(ch, -1, 0, nil)
+	native.PushNativeHistogram(ch, NativeHistogram{
+		Count:           3,
+		Sum:             2.5,
+		Schema:          0,
+		PositiveBuckets: map[int]int{0: 1, 1: 2},
+		Created:         time.Unix(1700000000, 0),
+	})
+	native.PushNativeHistogram(ch, NativeHistogram{Count: "many"})
+	close(ch)
+	var invalid int
+	for m := range ch {
+		if PushError(m) != nil {
+			invalid++
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	if len(metrics) != 3 || invalid != 3 {
+		t.Fatalf("got %d metrics and %d invalid ones, want 3 of each", len(metrics), invalid)
+	}
+
+	body := exposeOpenMetrics(t, func(ch chan<- prometheus.Metric) {
+		for _, m := range metrics {
+			ch <- m
+		}
+	})
+	for _, want := range []string{
+		`latency_seconds_bucket{path="/",le="0.1"} 3`,
+		`latency_seconds_bucket{path="/",le="+Inf"} 5`,
+		`latency_seconds_sum{path="/"} 1.5`,
+		`size_bytes{quantile="0.99"} 200.0`,
+		`size_bytes_count 2`,
+		`native_seconds_count 3`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestTryPushHistogramAndSummary(t *testing.T) {
+	d := TypedDesc{
+		Desc: prometheus.NewDesc("latency_seconds", "Latency.", nil, nil),
+	}
+	ch := make(chan prometheus.Metric, 10)
+	if err := d.TryPushHistogram(ch, 5, 1.5, map[string]int{"0.1": 3}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := d.TryPushHistogram(ch, 5, 1.5, map[string]int{"invalid": 3}); err == nil {
+		t.Error("expected an error for an invalid bucket")
+	}
+	if err := d.TryPushSummary(ch, 2, 300, map[float64]float64{0.5: 100}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := d.TryPushSummary(ch, -1, 0, nil); err == nil {
+		t.Error("expected an error for a negative count")
+	}
+	if err := d.TryPushNativeHistogram(ch, NativeHistogram{Count: 1, Sum: 1, PositiveBuckets: map[int]int{0: 1}, Created: time.Unix(1700000000, 0)}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := d.TryPushNativeHistogram(ch, NativeHistogram{Count: 1, Sum: 1, PositiveBuckets: "invalid"}); err == nil {
+		t.Error("expected an error for invalid buckets")
+	}
+	close(ch)
+	var n int
+	for m := range ch {
+		if err := PushError(m); err != nil {
+			t.Errorf("invalid metric pushed: %s", err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("got %d metrics, want 3 as the failed ones are not pushed", n)
+	}
+}
+
+func TestTryPushMetric(t *testing.T) {
+	d := TypedDesc{
+		Desc:      prometheus.NewDesc("value", "Value.", []string{"key"}, nil),
+		ValueType: prometheus.GaugeValue,
+	}
+	ch := make(chan prometheus.Metric, 4)
+	if err := d.TryPushMetric(ch, "1.5", "a"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := d.TryPushMetric(ch, "not a number", "b"); err == nil {
+		t.Error("expected an error for an unconvertible value")
+	}
+	if err := d.TryPushMetric(ch, 1); err == nil {
+		t.Error("expected an error for a wrong number of label values")
+	}
+	if len(ch) != 1 {
+		t.Errorf("got %d metrics, want 1", len(ch))
+	}
+
+	d.PushMetric(ch, 1)
+	<-ch
+	if err := PushError(<-ch); err == nil {
+		t.Error("PushMetric didn't push an invalid metric for a wrong number of label values")
+	}
+}
@@ -0,0 +1,166 @@
+package metric
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StructDescs is the set of descs declared by the tags of a struct type, create instances with NewStructDescs.
+//
+// Each field tagged metric is a metric, the tag gives its name and optionally its type (gauge, counter or untyped,
+// gauge by default). The help, labels and const_labels tags give its help, its variable label names and its constant
+// labels. Each field tagged label holds the value of the variable label it names, for all the metrics which have it.
+//
+//	type stats struct {
+//		Host     string  `label:"host"`
+//		Requests int     `metric:"requests_total,counter" help:"Number of requests." labels:"host"`
+//		Load     float64 `metric:"load" help:"Current load." labels:"host" const_labels:"unit=percent"`
+//		Queue    *int    `metric:"queue_length" help:"Length of the queue, if it has one."`
+//	}
+//
+// The fields of metrics accept any value type supported by util.AnyToFloat64, nil pointers are not pushed.
+type StructDescs struct {
+	typ     reflect.Type
+	metrics []structMetric
+	labels  map[string]int // labels records the index of the field holding the value of each label
+}
+
+// structMetric is a metric declared by a struct field.
+type structMetric struct {
+	index      int // index is the index of the field
+	desc       TypedDesc
+	labelNames []string
+}
+
+// NewStructDescs creates the descs declared by the tags of the struct type of v, which can be a struct or a pointer to a struct.
+// The metric names are built with namespace and subsystem like prometheus.BuildFQName.
+func NewStructDescs(namespace, subsystem string, v any) (*StructDescs, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't create descs from %T, a struct is required", v)
+	}
+
+	s := &StructDescs{
+		typ:    typ,
+		labels: make(map[string]int),
+	}
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if label, ok := field.Tag.Lookup("label"); ok {
+			if !field.IsExported() {
+				return nil, fmt.Errorf("field %s holds the %s label, it must be exported", field.Name, label)
+			}
+			if field.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("field %s holds the %s label, it must be a string", field.Name, label)
+			}
+			s.labels[label] = i
+		}
+	}
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup("metric")
+		if !ok {
+			continue
+		}
+		if !field.IsExported() {
+			return nil, fmt.Errorf("field %s is a metric, it must be exported", field.Name)
+		}
+		m, err := s.newStructMetric(namespace, subsystem, i, field, tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		s.metrics = append(s.metrics, m)
+	}
+	return s, nil
+}
+
+// newStructMetric creates the metric declared by field, tag is its metric tag.
+func (s *StructDescs) newStructMetric(namespace, subsystem string, index int, field reflect.StructField, tag string) (structMetric, error) {
+	name, valueTypeName, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return structMetric{}, fmt.Errorf("missing metric name in tag %q", tag)
+	}
+	var valueType prometheus.ValueType
+	switch valueTypeName {
+	case "", "gauge":
+		valueType = prometheus.GaugeValue
+	case "counter":
+		valueType = prometheus.CounterValue
+	case "untyped":
+		valueType = prometheus.UntypedValue
+	default:
+		return structMetric{}, fmt.Errorf("unsupported metric type %q", valueTypeName)
+	}
+
+	var labelNames []string
+	if labels := field.Tag.Get("labels"); labels != "" {
+		labelNames = strings.Split(labels, ",")
+	}
+	for _, label := range labelNames {
+		if _, ok := s.labels[label]; !ok {
+			return structMetric{}, fmt.Errorf("no field holds the value of the %s label", label)
+		}
+	}
+
+	var constLabels prometheus.Labels
+	if labels := field.Tag.Get("const_labels"); labels != "" {
+		constLabels = make(prometheus.Labels)
+		for _, pair := range strings.Split(labels, ",") {
+			label, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return structMetric{}, fmt.Errorf("invalid constant label %q, it must be name=value", pair)
+			}
+			constLabels[label] = value
+		}
+	}
+
+	return structMetric{
+		index: index,
+		desc: TypedDesc{
+			Desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), field.Tag.Get("help"), labelNames, constLabels),
+			ValueType: valueType,
+		},
+		labelNames: labelNames,
+	}, nil
+}
+
+// Descs returns the descs of all the metrics, e.g. to describe them.
+func (s *StructDescs) Descs() []TypedDesc {
+	descs := make([]TypedDesc, 0, len(s.metrics))
+	for _, m := range s.metrics {
+		descs = append(descs, m.desc)
+	}
+	return descs
+}
+
+// Push pushes the metrics of all the fields of value, which must be of the struct type given to NewStructDescs
+// or a pointer to it. Like PushMetric, a metric which can't be created is pushed as an invalid metric.
+func (s *StructDescs) Push(ch chan<- prometheus.Metric, value any) {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if !v.IsValid() || v.Type() != s.typ {
+		for _, m := range s.metrics {
+			pushInvalid(ch, m.desc.Desc, fmt.Errorf("can't push %T as %s", value, s.typ))
+		}
+		return
+	}
+	for _, m := range s.metrics {
+		field := v.Field(m.index)
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		labelValues := make([]string, 0, len(m.labelNames))
+		for _, label := range m.labelNames {
+			labelValues = append(labelValues, v.Field(s.labels[label]).String())
+		}
+		m.desc.PushMetric(ch, field.Interface(), labelValues...)
+	}
+}
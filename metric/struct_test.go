@@ -0,0 +1,68 @@
+package metric
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type testStats struct {
+	Host     string `label:"host"`
+	Requests int    `metric:"requests_total,counter" help:"Number of requests." labels:"host"`
+	Load     string `metric:"load" help:"Current load." labels:"host" const_labels:"unit=percent"`
+	Queue    *int   `metric:"queue_length" help:"Length of the queue."`
+	Ignored  float64
+}
+
+func TestStructDescs(t *testing.T) {
+	descs, err := NewStructDescs("test", "stats", testStats{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(descs.Descs()); n != 3 {
+		t.Errorf("got %d descs, want 3", n)
+	}
+
+	expected := `
+# HELP test_stats_load Current load.
+# TYPE test_stats_load gauge
+test_stats_load{host="a",unit="percent"} 0.5
+# HELP test_stats_requests_total Number of requests.
+# TYPE test_stats_requests_total counter
+test_stats_requests_total{host="a"} 42
+`
+	c := pushCollector(func(ch chan<- prometheus.Metric) {
+		descs.Push(ch, &testStats{Host: "a", Requests: 42, Load: "0.5"})
+	})
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	queue := 3
+	ch := make(chan prometheus.Metric, 3)
+	descs.Push(ch, testStats{Load: "1", Queue: &queue})
+	if len(ch) != 3 {
+		t.Errorf("got %d metrics, want 3 as the queue length is set", len(ch))
+	}
+}
+
+func TestStructDescsInvalid(t *testing.T) {
+	for name, v := range map[string]any{
+		"not a struct": 1,
+		"unknown type": struct {
+			V int `metric:"v,histogram"`
+		}{},
+		"missing label": struct {
+			V int `metric:"v" labels:"host"`
+		}{},
+		"unexported": struct {
+			v int `metric:"v"`
+		}{},
+	} {
+		if _, err := NewStructDescs("test", "", v); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package exporter
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// The values of the --push.format flag.
+const (
+	pushFormatPushgateway = "pushgateway"
+	pushFormatRemoteWrite = "remote-write"
+)
+
+const (
+	pushAttempts     = 3           // pushAttempts is the number of attempts of a push before it is counted as failed
+	pushRetryBackoff = time.Second // pushRetryBackoff is the wait before the second attempt of a push, it doubles for every further attempt
+)
+
+// pusher pushes the metrics of the unfiltered handler on a timer, to a Pushgateway or a remote-write endpoint.
+// Create instances with newPusher.
+type pusher struct {
+	url          string
+	format       string // format is one of pushFormatPushgateway and pushFormatRemoteWrite
+	job          string // job is the job of the pushed metrics
+	instance     string // instance is the instance of the pushed metrics, it is not set if empty
+	interval     time.Duration
+	retryBackoff time.Duration
+	handler      *handler
+	client       *http.Client
+	failures     prometheus.Counter // failures counts the pushes which failed after pushAttempts attempts
+	logger       *slog.Logger
+
+	mtx    sync.Mutex
+	cancel context.CancelFunc // cancel stops the pushes, it is set by start
+	done   chan struct{}      // done is closed once run returned, it is set by start
+}
+
+// newPusher creates a pusher, its handler has to be set before it runs. The failures metric is
+// created first, so that it can be served and pushed along with the metrics of the handler.
+// job is the exporter name in snake case, it is also used in the help of the failures metric.
+func newPusher(url, format, job, instance, namespace string, interval time.Duration, logger *slog.Logger) *pusher {
+	return &pusher{
+		url:          url,
+		format:       format,
+		job:          job,
+		instance:     instance,
+		interval:     interval,
+		retryBackoff: pushRetryBackoff,
+		client:       &http.Client{},
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "push_failures_total",
+			Help:      job + ": Number of pushes which failed after all their attempts.",
+		}),
+		logger: logger.With("url", url, "format", format),
+	}
+}
+
+// start runs the pushes in the background until ctx is done or stop is called.
+func (p *pusher) start(ctx context.Context) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	ctx, p.cancel = context.WithCancel(ctx)
+	done := make(chan struct{})
+	p.done = done
+	go func() {
+		defer close(done)
+		p.run(ctx)
+	}()
+}
+
+// stop stops the pushes started by start and waits until the current push returned or ctx is done.
+// It can be called more than once, and before start.
+func (p *pusher) stop(ctx context.Context) error {
+	p.mtx.Lock()
+	cancel, done := p.cancel, p.done
+	p.mtx.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run pushes the metrics every p.interval until ctx is done, starting right away.
+func (p *pusher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.push(ctx); err != nil && ctx.Err() == nil {
+			p.failures.Inc()
+			p.logger.Error("Couldn't push metrics", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// push gathers and pushes the metrics, it retries up to pushAttempts attempts within p.interval.
+func (p *pusher) push(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+	backoff := p.retryBackoff
+	var err error
+	for attempt := 1; attempt <= pushAttempts; attempt++ {
+		if err = p.pushOnce(ctx); err == nil {
+			p.logger.Debug("Pushed metrics", "attempt", attempt)
+			return nil
+		}
+		if attempt == pushAttempts {
+			break
+		}
+		p.logger.Warn("Couldn't push metrics, retrying", "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// pushOnce gathers and pushes the metrics once.
+func (p *pusher) pushOnce(ctx context.Context) error {
+	g, err := p.handler.unfilteredGatherer(ctx)
+	if err != nil {
+		return err
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		// Like the metrics handler, push what could be gathered.
+		p.logger.Warn("Error gathering metrics", "err", err)
+	}
+	switch p.format {
+	case pushFormatRemoteWrite:
+		return p.remoteWrite(ctx, mfs)
+	default:
+		return p.pushgateway(ctx, mfs)
+	}
+}
+
+// pushgatewayGroup is a grouping key of the Pushgateway, instance is not part of it if empty.
+type pushgatewayGroup struct {
+	job      string
+	instance string
+}
+
+// pushgateway pushes mfs to the Pushgateway, one request per grouping key, see splitPushgatewayGroups.
+func (p *pusher) pushgateway(ctx context.Context, mfs []*dto.MetricFamily) error {
+	groups := splitPushgatewayGroups(mfs, pushgatewayGroup{job: p.job, instance: p.instance})
+	var errs []error
+	for _, group := range slices.SortedFunc(maps.Keys(groups), func(a, b pushgatewayGroup) int {
+		return cmp.Or(strings.Compare(a.job, b.job), strings.Compare(a.instance, b.instance))
+	}) {
+		families := groups[group]
+		pusher := push.New(p.url, group.job)
+		if group.instance != "" {
+			pusher = pusher.Grouping(model.InstanceLabel, group.instance)
+		}
+		gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil })
+		if err := pusher.Gatherer(gatherer).Client(p.client).PushContext(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitPushgatewayGroups splits mfs by grouping key. The Pushgateway rejects the metrics which have a label of their
+// grouping key, so like in encodeWriteRequest, the job and instance labels of a metric take precedence over the ones
+// of defaultGroup: they are removed from the metric and it is pushed to their grouping key instead.
+// The result always contains defaultGroup, so that the metrics of the exporter are replaced even if there are none.
+func splitPushgatewayGroups(mfs []*dto.MetricFamily, defaultGroup pushgatewayGroup) map[pushgatewayGroup][]*dto.MetricFamily {
+	groups := map[pushgatewayGroup][]*dto.MetricFamily{defaultGroup: nil}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			group := defaultGroup
+			labels := make([]*dto.LabelPair, 0, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				switch {
+				case lp.GetName() == model.JobLabel && lp.GetValue() != "":
+					group.job = lp.GetValue()
+				case lp.GetName() == model.InstanceLabel && lp.GetValue() != "":
+					group.instance = lp.GetValue()
+				case lp.GetName() == model.JobLabel, lp.GetName() == model.InstanceLabel:
+					// An empty label is the same as a missing one.
+				default:
+					labels = append(labels, lp)
+				}
+			}
+			if len(labels) != len(m.GetLabel()) {
+				// The gathered metrics can be shared by coalesced scrapes, so they are not modified.
+				m = proto.Clone(m).(*dto.Metric)
+				m.Label = labels
+			}
+
+			families := groups[group]
+			if len(families) == 0 || families[len(families)-1].GetName() != mf.GetName() {
+				families = append(families, &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Unit: mf.Unit})
+			}
+			last := families[len(families)-1]
+			last.Metric = append(last.Metric, m)
+			groups[group] = families
+		}
+	}
+	return groups
+}
+
+// remoteWrite sends mfs to the remote-write endpoint as a snappy-compressed protobuf WriteRequest (remote write 1.0).
+func (p *pusher) remoteWrite(ctx context.Context, mfs []*dto.MetricFamily) error {
+	body := s2.EncodeSnappy(nil, encodeWriteRequest(mfs, p.job, p.instance, time.Now()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, p.url, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes mfs as a remote write 1.0 WriteRequest. Every metric is given the job label, and the
+// instance label if instance is not empty, unless it has one. The samples without timestamp are given now. Histograms and summaries are split into their
+// _bucket, quantile, _sum and _count series like in the text format.
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(mfs []*dto.MetricFamily, job, instance string, now time.Time) []byte {
+	var b []byte
+	appendSeries := func(name string, m *dto.Metric, value float64, extra ...string) {
+		labels := []string{model.MetricNameLabel, name}
+		hasJob, hasInstance := false, false
+		for _, lp := range m.GetLabel() {
+			labels = append(labels, lp.GetName(), lp.GetValue())
+			hasJob = hasJob || lp.GetName() == model.JobLabel
+			hasInstance = hasInstance || lp.GetName() == model.InstanceLabel
+		}
+		labels = append(labels, extra...)
+		if !hasJob {
+			labels = append(labels, model.JobLabel, job)
+		}
+		if !hasInstance && instance != "" {
+			labels = append(labels, model.InstanceLabel, instance)
+		}
+		timestamp := now.UnixMilli()
+		if m.TimestampMs != nil {
+			timestamp = m.GetTimestampMs()
+		}
+
+		var series []byte
+		for _, i := range sortedLabelIndexes(labels) {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, labels[i])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, labels[i+1])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, series)
+	}
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				appendSeries(name, m, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				appendSeries(name, m, m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					appendSeries(name, m, q.GetValue(), model.QuantileLabel, fmt.Sprint(q.GetQuantile()))
+				}
+				appendSeries(name+"_sum", m, s.GetSampleSum())
+				appendSeries(name+"_count", m, float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), +1) {
+						continue
+					}
+					appendSeries(name+"_bucket", m, float64(bucket.GetCumulativeCount()), model.BucketLabel, fmt.Sprint(bucket.GetUpperBound()))
+				}
+				appendSeries(name+"_bucket", m, float64(h.GetSampleCount()), model.BucketLabel, "+Inf")
+				appendSeries(name+"_sum", m, h.GetSampleSum())
+				appendSeries(name+"_count", m, float64(h.GetSampleCount()))
+			default:
+				appendSeries(name, m, m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return b
+}
+
+// sortedLabelIndexes returns the indexes of the names in labels, a list of name value pairs, sorted by name
+// as required by remote write.
+func sortedLabelIndexes(labels []string) []int {
+	indexes := make([]int, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		indexes = append(indexes, i)
+	}
+	slices.SortFunc(indexes, func(a, b int) int {
+		return strings.Compare(labels[a], labels[b])
+	})
+	return indexes
+}
@@ -0,0 +1,287 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/rea1shane/exporter/collector"
+)
+
+func newTestPushExporter(t *testing.T, url, format string) *Exporter {
+	t.Helper()
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      newTestRegistry(),
+		Args:          []string{"--push.url=" + url, "--push.format=" + format, "--push.instance=test_host", "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.pusher.retryBackoff = time.Millisecond
+	return e
+}
+
+func TestPushPushgateway(t *testing.T) {
+	var path string
+	var families []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.Path
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			mf := &dto.MetricFamily{}
+			if err := decoder.Decode(mf); err != nil {
+				break
+			}
+			families = append(families, mf.GetName())
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	e := newTestPushExporter(t, receiver.URL, pushFormatPushgateway)
+	if err := e.pusher.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "PUT /metrics/job/test_exporter/instance/test_host"; path != want {
+		t.Errorf("pushed to %q, want %s", path, want)
+	}
+	for _, want := range []string{"test_test_up", "test_push_failures_total"} {
+		if !slices.Contains(families, want) {
+			t.Errorf("pushed metrics don't contain %s: %v", want, families)
+		}
+	}
+}
+
+type labelledCollector struct {
+	namespace string
+}
+
+func (c labelledCollector) Update(ch chan<- prometheus.Metric) error {
+	up := prometheus.NewDesc(prometheus.BuildFQName(c.namespace, "labelled", "up"), "Labelled metric.", []string{"instance"}, nil)
+	ch <- prometheus.MustNewConstMetric(up, prometheus.GaugeValue, 1, "db1")
+	// A duplicate metric makes the gathering fail partially.
+	duplicate := prometheus.NewDesc(prometheus.BuildFQName(c.namespace, "labelled", "duplicate"), "Duplicate metric.", nil, nil)
+	ch <- prometheus.MustNewConstMetric(duplicate, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(duplicate, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestPushPushgatewayLabelled(t *testing.T) {
+	var mtx sync.Mutex
+	pushed := map[string][]*dto.MetricFamily{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		var families []*dto.MetricFamily
+		for {
+			mf := &dto.MetricFamily{}
+			if err := decoder.Decode(mf); err != nil {
+				break
+			}
+			families = append(families, mf)
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		pushed[r.URL.Path] = families
+	}))
+	defer receiver.Close()
+
+	registry := newTestRegistry()
+	registry.RegisterCollector("labelled", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return labelledCollector{namespace: namespace}, nil
+	})
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      registry,
+		Args:          []string{"--push.url=" + receiver.URL, "--push.instance=test_host", "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.pusher.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(path string) []string {
+		var names []string
+		for _, mf := range pushed[path] {
+			names = append(names, mf.GetName())
+		}
+		return names
+	}
+	if names := names("/metrics/job/test_exporter/instance/test_host"); !slices.Contains(names, "test_test_up") || slices.Contains(names, "test_labelled_up") {
+		t.Errorf("unexpected metrics pushed to the group of the exporter: %v", names)
+	}
+	db1 := pushed["/metrics/job/test_exporter/instance/db1"]
+	if len(db1) != 1 || db1[0].GetName() != "test_labelled_up" {
+		t.Fatalf("unexpected metrics pushed to the group of the labelled metric: %v", names("/metrics/job/test_exporter/instance/db1"))
+	}
+	if labels := db1[0].GetMetric()[0].GetLabel(); len(labels) != 0 {
+		t.Errorf("the grouping labels are pushed as metric labels: %v", labels)
+	}
+}
+
+func TestPushInstanceDefault(t *testing.T) {
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Registry:      newTestRegistry(),
+		Args:          []string{"--push.url=http://localhost", "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostname, _ := os.Hostname(); e.pusher.instance != hostname {
+		t.Errorf("instance is %q, want the hostname %q", e.pusher.instance, hostname)
+	}
+}
+
+func TestPushRemoteWriteRetry(t *testing.T) {
+	var requests atomic.Int32
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("unexpected Content-Encoding %q", r.Header.Get("Content-Encoding"))
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		var err error
+		if body, err = s2.Decode(nil, compressed); err != nil {
+			t.Errorf("couldn't decode snappy body: %s", err)
+		}
+	}))
+	defer receiver.Close()
+
+	e := newTestPushExporter(t, receiver.URL, pushFormatRemoteWrite)
+	if err := e.pusher.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2 as the first one failed", n)
+	}
+	for _, want := range []string{"__name__", "test_test_up", "job", "test_exporter", "instance", "test_host"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("write request doesn't contain %q", want)
+		}
+	}
+}
+
+func TestPushFailures(t *testing.T) {
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	e := newTestPushExporter(t, receiver.URL, pushFormatRemoteWrite)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.pusher.run(ctx)
+	}()
+	for requests.Load() < pushAttempts {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	if n := requests.Load(); n != pushAttempts {
+		t.Errorf("got %d requests, want %d attempts", n, pushAttempts)
+	}
+	if v := testutil.ToFloat64(e.pusher.failures); v != 1 {
+		t.Errorf("push_failures_total is %v, want 1", v)
+	}
+}
+
+type shutdownRecorder struct {
+	testCollector
+	shutdown func()
+}
+
+func (c shutdownRecorder) Shutdown(ctx context.Context) error {
+	c.shutdown()
+	return nil
+}
+
+func TestPushStoppedBeforeShutdown(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Hang until the push is cancelled, or the test is done.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer receiver.Close()
+	defer close(release)
+
+	var (
+		e               *Exporter
+		pushingShutdown atomic.Bool
+	)
+	registry := collector.NewRegistry()
+	registry.RegisterCollector("test", collector.DefaultEnabled, func(namespace string, logger *slog.Logger) (collector.Collector, error) {
+		return shutdownRecorder{
+			testCollector: testCollector{desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "test", "up"), "Test metric.", nil, nil)},
+			shutdown: func() {
+				select {
+				case <-e.pusher.done:
+				default:
+					pushingShutdown.Store(true)
+				}
+			},
+		}, nil
+	})
+	e, err := New(Options{
+		SnakeCaseName: "test_exporter",
+		Namespace:     "test",
+		Registry:      registry,
+		Args:          []string{"--push.url=" + receiver.URL, "--log.level=error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.pusher.start(context.Background())
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := e.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pushingShutdown.Load() {
+		t.Error("collectors were shut down while the pusher was running")
+	}
+	select {
+	case <-e.pusher.done:
+	default:
+		t.Error("Stop didn't stop the pusher")
+	}
+}
@@ -37,6 +37,9 @@ func AnyToFloat64(data any) (float64, error) {
 	default:
 		v := reflect.ValueOf(data)
 		v = reflect.Indirect(v)
+		if !v.IsValid() {
+			return math.NaN(), fmt.Errorf("can't convert nil %T to float64", data)
+		}
 		if v.Type().ConvertibleTo(floatType) {
 			fv := v.Convert(floatType)
 			return fv.Float(), nil
@@ -49,3 +52,95 @@ func AnyToFloat64(data any) (float64, error) {
 		}
 	}
 }
+
+func AnyToInt64(data any) (int64, error) {
+	switch d := data.(type) {
+	case int64:
+		return d, nil
+	case int32:
+		return int64(d), nil
+	case int:
+		return int64(d), nil
+	case uint32:
+		return int64(d), nil
+	case uint64:
+		if d > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows int64", d)
+		}
+		return int64(d), nil
+	case uint:
+		if uint64(d) > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows int64", d)
+		}
+		return int64(d), nil
+	case string:
+		if i, err := strconv.ParseInt(d, 10, 64); err == nil {
+			return i, nil
+		}
+	}
+	f, err := AnyToFloat64(data)
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("can't convert %v to int64", f)
+	}
+	return int64(f), nil
+}
+
+func AnyToInt(data any) (int, error) {
+	i, err := AnyToInt64(data)
+	if err != nil {
+		return 0, err
+	}
+	if i < math.MinInt || i > math.MaxInt {
+		return 0, fmt.Errorf("%d overflows int", i)
+	}
+	return int(i), nil
+}
+
+func AnyToUint64(data any) (uint64, error) {
+	switch d := data.(type) {
+	case uint64:
+		return d, nil
+	case uint32:
+		return uint64(d), nil
+	case uint:
+		return uint64(d), nil
+	case string:
+		if u, err := strconv.ParseUint(d, 10, 64); err == nil {
+			return u, nil
+		}
+	}
+	i, err := AnyToInt64(data)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("can't convert negative %d to uint64", i)
+	}
+	return uint64(i), nil
+}
+
+// AnyToMap converts a map of any key and value types with the given key and value conversion functions.
+// For example, AnyToMap(data, AnyToFloat64, AnyToUint64) converts histogram buckets like map[string]int{"0.5": 3, "+Inf": 5}.
+func AnyToMap[K comparable, V any](data any, key func(any) (K, error), value func(any) (V, error)) (map[K]V, error) {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Map {
+		return nil, fmt.Errorf("can't convert %T to map", data)
+	}
+	m := make(map[K]V, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		k, err := key(iter.Key().Interface())
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", iter.Key(), err)
+		}
+		val, err := value(iter.Value().Interface())
+		if err != nil {
+			return nil, fmt.Errorf("value of %v: %w", iter.Key(), err)
+		}
+		m[k] = val
+	}
+	return m, nil
+}
@@ -78,3 +78,111 @@ func getFloatSwitchOnly(unk interface{}) (float64, error) {
 		return math.NaN(), errUnexpectedType
 	}
 }
+
+func TestAnyToInt64(t *testing.T) {
+	for _, tc := range []struct {
+		data    any
+		want    int64
+		wantErr bool
+	}{
+		{data: 42, want: 42},
+		{data: int32(-7), want: -7},
+		{data: uint64(math.MaxInt64), want: math.MaxInt64},
+		{data: uint64(math.MaxInt64) + 1, wantErr: true},
+		{data: uint(math.MaxUint), wantErr: true},
+		{data: 3.0, want: 3},
+		{data: 3.5, wantErr: true},
+		{data: math.Inf(1), wantErr: true},
+		{data: "-12", want: -12},
+		{data: "1e3", want: 1000},
+		{data: "9223372036854775808", wantErr: true},
+		{data: "abc", wantErr: true},
+		{data: []int{1}, wantErr: true},
+		{data: nil, wantErr: true},
+	} {
+		got, err := AnyToInt64(tc.data)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("AnyToInt64(%#v) = %d, %v, want %d and error %t", tc.data, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestAnyToInt(t *testing.T) {
+	for _, tc := range []struct {
+		data    any
+		want    int
+		wantErr bool
+	}{
+		{data: int64(-5), want: -5},
+		{data: "17", want: 17},
+		{data: 1.5, wantErr: true},
+		{data: "x", wantErr: true},
+	} {
+		got, err := AnyToInt(tc.data)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("AnyToInt(%#v) = %d, %v, want %d and error %t", tc.data, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestAnyToUint64(t *testing.T) {
+	for _, tc := range []struct {
+		data    any
+		want    uint64
+		wantErr bool
+	}{
+		{data: uint64(math.MaxUint64), want: math.MaxUint64},
+		{data: uint32(3), want: 3},
+		{data: 3, want: 3},
+		{data: 0, want: 0},
+		{data: -1, wantErr: true},
+		{data: int64(math.MinInt64), wantErr: true},
+		{data: -1.0, wantErr: true},
+		{data: "18446744073709551615", want: math.MaxUint64},
+		{data: "18446744073709551616", wantErr: true},
+		{data: "-3", wantErr: true},
+		{data: "2.5", wantErr: true},
+		{data: struct{}{}, wantErr: true},
+	} {
+		got, err := AnyToUint64(tc.data)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("AnyToUint64(%#v) = %d, %v, want %d and error %t", tc.data, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestAnyToMap(t *testing.T) {
+	got, err := AnyToMap(map[string]int{"0.5": 3, "+Inf": 5}, AnyToFloat64, AnyToUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0.5] != 3 || got[math.Inf(1)] != 5 {
+		t.Errorf("unexpected map: %v", got)
+	}
+
+	value := 2
+	got2, err := AnyToMap(&map[int]*int{1: &value}, AnyToInt, AnyToInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got2[1] != 2 {
+		t.Errorf("unexpected map: %v", got2)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data any
+	}{
+		{name: "not a map", data: []int{1, 2}},
+		{name: "nil", data: nil},
+		{name: "bad key", data: map[string]int{"x": 1}},
+		{name: "bad key type", data: map[[1]int]int{{1}: 1}},
+		{name: "bad value", data: map[string]string{"1": "x"}},
+		{name: "negative value", data: map[string]int{"1": -1}},
+		{name: "nil value", data: map[string]any{"1": nil}},
+	} {
+		if _, err := AnyToMap(tc.data, AnyToFloat64, AnyToUint64); err == nil {
+			t.Errorf("%s: expected an error for %#v", tc.name, tc.data)
+		}
+	}
+}